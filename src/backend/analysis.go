@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Use struct {
+	Result string `json:"result"`
+	With   string `json:"with"`
+	Tier   int    `json:"tier"`
+	Legal  bool   `json:"legal"`
+}
+
+type Descendant struct {
+	Element string `json:"element"`
+	Tier    int    `json:"tier"`
+	Depth   int    `json:"depth"`
+}
+
+// craftableSet returns every element that has at least one legal recipe
+// tree under cons, built bottom-up from the basic elements.
+func craftableSet(cons *Constraints) map[string]bool {
+	craftable := make(map[string]bool)
+	for elem := range craftRanks(cons) {
+		craftable[elem] = true
+	}
+	return craftable
+}
+
+// craftRanks assigns every craftable element the round in which bottom-up
+// construction from the basic elements first reaches it: basics are rank 0,
+// and an element has rank k when it has a combination allowed by cons whose
+// ingredients both have rank below k. Ranks always decrease from an element
+// to the ingredients of that combination, so they order recipes even when
+// the policy lets the combination graph have cycles.
+func craftRanks(cons *Constraints) map[string]int {
+	ranks := make(map[string]int)
+	for _, elem := range getSortedBasicElements() {
+		if cons.AllowsElement(elem) {
+			ranks[elem] = 0
+		}
+	}
+
+	for round := 1; ; round++ {
+		var reached []string
+		for elem, combos := range combinations {
+			if _, done := ranks[elem]; done {
+				continue
+			}
+			for _, c := range combos {
+				left, leftOK := ranks[c.Left]
+				right, rightOK := ranks[c.Right]
+				if leftOK && rightOK && left < round && right < round && cons.Allows(c) {
+					reached = append(reached, elem)
+					break
+				}
+			}
+		}
+		if len(reached) == 0 {
+			return ranks
+		}
+		for _, elem := range reached {
+			ranks[elem] = round
+		}
+	}
+}
+
+// FindUses lists every combination that takes element as an ingredient.
+func FindUses(element string) []Use {
+	uses := []Use{}
+	seen := make(map[string]bool)
+	for _, product := range reverseMap[element] {
+		if seen[product] {
+			continue
+		}
+		seen[product] = true
+
+		for _, c := range combinations[product] {
+			var with string
+			switch element {
+			case c.Left:
+				with = c.Right
+			case c.Right:
+				with = c.Left
+			default:
+				continue
+			}
+			uses = append(uses, Use{
+				Result: c.Root,
+				With:   with,
+				Tier:   tierMap[c.Root],
+				Legal:  IsLowerTier(c),
+			})
+		}
+	}
+
+	sort.Slice(uses, func(i, j int) bool {
+		if uses[i].Result != uses[j].Result {
+			return uses[i].Result < uses[j].Result
+		}
+		return uses[i].With < uses[j].With
+	})
+	return uses
+}
+
+// FindDescendants walks reverseMap outward from element and returns every
+// element that has a legal recipe using it, together with the smallest
+// number of combinations separating the two. The partner ingredient of each
+// combination must itself be craftable. maxDepth <= 0 means unlimited.
+func FindDescendants(element string, maxDepth int) []Descendant {
+	craftable := craftableSet(nil)
+	depth := map[string]int{element: 0}
+	queue := []string{element}
+	descendants := []Descendant{}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if maxDepth > 0 && depth[current] >= maxDepth {
+			continue
+		}
+
+		for _, use := range FindUses(current) {
+			if !use.Legal || !craftable[use.With] {
+				continue
+			}
+			if _, seen := depth[use.Result]; seen {
+				continue
+			}
+			depth[use.Result] = depth[current] + 1
+			descendants = append(descendants, Descendant{
+				Element: use.Result,
+				Tier:    use.Tier,
+				Depth:   depth[use.Result],
+			})
+			queue = append(queue, use.Result)
+		}
+	}
+
+	sort.SliceStable(descendants, func(i, j int) bool {
+		if descendants[i].Depth != descendants[j].Depth {
+			return descendants[i].Depth < descendants[j].Depth
+		}
+		return descendants[i].Element < descendants[j].Element
+	})
+	return descendants
+}
+
+func handleUses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	element := r.PathValue("name")
+	if _, exists := tierMap[element]; !exists {
+		http.Error(w, "Element not found", http.StatusNotFound)
+		return
+	}
+
+	uses := FindUses(element)
+	var response struct {
+		Element string `json:"element"`
+		Tier    int    `json:"tier"`
+		Count   int    `json:"count"`
+		Uses    []Use  `json:"uses"`
+	}
+	response.Element = element
+	response.Tier = tierMap[element]
+	response.Count = len(uses)
+	response.Uses = uses
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func handleDescendants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	element := r.PathValue("name")
+	if _, exists := tierMap[element]; !exists {
+		http.Error(w, "Element not found", http.StatusNotFound)
+		return
+	}
+
+	maxDepth := 0
+	if depthStr := r.URL.Query().Get("depth"); depthStr != "" {
+		parsed, err := strconv.Atoi(depthStr)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid depth", http.StatusBadRequest)
+			return
+		}
+		maxDepth = parsed
+	}
+
+	descendants := FindDescendants(element, maxDepth)
+	var response struct {
+		Element     string       `json:"element"`
+		Depth       int          `json:"depth"`
+		Count       int          `json:"count"`
+		Descendants []Descendant `json:"descendants"`
+	}
+	response.Element = element
+	response.Depth = maxDepth
+	response.Count = len(descendants)
+	response.Descendants = descendants
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type IngredientReport struct {
+	Element   string   `json:"element"`
+	Craftable bool     `json:"craftable"`
+	Mandatory []string `json:"mandatory"`
+	Avoidable []string `json:"avoidable"`
+}
+
+// mandatorySets computes, for every craftable element, the set of elements
+// that appear in every legal recipe tree for it. Each element's set is
+// {element} joined with the intersection, over its legal combinations, of
+// the union of both ingredients' sets. A nil set stands for "everything" and
+// is refined downward until nothing changes, so the greatest fixed point is
+// reached even when the combination graph has cycles.
+func mandatorySets(craftable map[string]bool, cons *Constraints) map[string]map[string]bool {
+	order := make([]string, 0, len(craftable))
+	for elem := range craftable {
+		order = append(order, elem)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if tierMap[order[i]] != tierMap[order[j]] {
+			return tierMap[order[i]] < tierMap[order[j]]
+		}
+		return order[i] < order[j]
+	})
+
+	sets := make(map[string]map[string]bool)
+	for _, elem := range order {
+		if isBasic(elem) {
+			sets[elem] = map[string]bool{elem: true}
+		} else {
+			sets[elem] = nil
+		}
+	}
+
+	changed := true
+	for changed {
+		changed = false
+		for _, elem := range order {
+			if isBasic(elem) {
+				continue
+			}
+
+			var next map[string]bool
+			top := true
+			for _, c := range combinations[elem] {
+				if !cons.Allows(c) || !craftable[c.Left] || !craftable[c.Right] {
+					continue
+				}
+				left, right := sets[c.Left], sets[c.Right]
+				if left == nil || right == nil {
+					continue
+				}
+				union := make(map[string]bool, len(left)+len(right))
+				for e := range left {
+					union[e] = true
+				}
+				for e := range right {
+					union[e] = true
+				}
+				if top {
+					next = union
+					top = false
+					continue
+				}
+				for e := range next {
+					if !union[e] {
+						delete(next, e)
+					}
+				}
+			}
+			if top {
+				continue
+			}
+			next[elem] = true
+
+			if sets[elem] == nil || len(next) != len(sets[elem]) {
+				sets[elem] = next
+				changed = true
+			}
+		}
+	}
+	return sets
+}
+
+// possibleIngredients returns every element that appears in at least one
+// legal recipe tree for target, target included.
+func possibleIngredients(target string, craftable map[string]bool, cons *Constraints) map[string]bool {
+	possible := map[string]bool{target: true}
+	stack := []string{target}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, c := range combinations[current] {
+			if !cons.Allows(c) || !craftable[c.Left] || !craftable[c.Right] {
+				continue
+			}
+			for _, ingredient := range []string{c.Left, c.Right} {
+				if !possible[ingredient] {
+					possible[ingredient] = true
+					stack = append(stack, ingredient)
+				}
+			}
+		}
+	}
+	return possible
+}
+
+// AnalyzeIngredients splits the ingredients of target into the ones every
+// recipe allowed by cons needs and the ones some recipe can do without.
+func AnalyzeIngredients(target string, cons *Constraints) IngredientReport {
+	report := IngredientReport{
+		Element:   target,
+		Mandatory: []string{},
+		Avoidable: []string{},
+	}
+
+	craftable := craftableSet(cons)
+	if !craftable[target] {
+		return report
+	}
+	report.Craftable = true
+
+	mandatory := mandatorySets(craftable, cons)[target]
+	for elem := range possibleIngredients(target, craftable, cons) {
+		if elem == target {
+			continue
+		}
+		if mandatory[elem] {
+			report.Mandatory = append(report.Mandatory, elem)
+		} else {
+			report.Avoidable = append(report.Avoidable, elem)
+		}
+	}
+	sort.Strings(report.Mandatory)
+	sort.Strings(report.Avoidable)
+	return report
+}
+
+// CanAvoid reports whether target can be crafted without ever making avoid.
+func (r IngredientReport) CanAvoid(avoid string) bool {
+	if !r.Craftable {
+		return false
+	}
+	i := sort.SearchStrings(r.Mandatory, avoid)
+	return i == len(r.Mandatory) || r.Mandatory[i] != avoid
+}
+
+func handleMandatory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	element := r.PathValue("name")
+	if _, exists := tierMap[element]; !exists {
+		http.Error(w, "Element not found", http.StatusNotFound)
+		return
+	}
+
+	cons, err := ParseConstraints(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type avoidance struct {
+		Element  string `json:"element"`
+		Possible bool   `json:"possible"`
+	}
+	var response struct {
+		IngredientReport
+		Without []avoidance `json:"without,omitempty"`
+	}
+	response.IngredientReport = AnalyzeIngredients(element, cons)
+	for _, avoid := range splitList(r.URL.Query().Get("without")) {
+		response.Without = append(response.Without, avoidance{
+			Element:  avoid,
+			Possible: response.CanAvoid(avoid),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// splitList splits a comma-separated query value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+type WhatIfReport struct {
+	Removed     string   `json:"removed"`
+	Unreachable []string `json:"unreachable"`
+}
+
+// unreachableUnder lists the elements that are craftable with no
+// constraints but lose every legal recipe under cons. Elements excluded by
+// cons itself are not listed.
+func unreachableUnder(cons *Constraints, base map[string]bool) []string {
+	craftable := craftableSet(cons)
+	unreachable := []string{}
+	for elem := range base {
+		if !craftable[elem] && cons.AllowsElement(elem) {
+			unreachable = append(unreachable, elem)
+		}
+	}
+	sort.Strings(unreachable)
+	return unreachable
+}
+
+// WhatIf reports which elements become unreachable when everything in cons
+// is removed at once, followed by one report per removed element or combo.
+func WhatIf(cons *Constraints) (combined []string, each []WhatIfReport) {
+	base := craftableSet(nil)
+	combined = unreachableUnder(cons, base)
+
+	var elems, combos []string
+	for elem := range cons.ExcludedElements {
+		elems = append(elems, elem)
+	}
+	for combo := range cons.ExcludedCombos {
+		combos = append(combos, combo)
+	}
+	sort.Strings(elems)
+	sort.Strings(combos)
+
+	each = []WhatIfReport{}
+	for _, elem := range elems {
+		single := &Constraints{ExcludedElements: map[string]bool{elem: true}}
+		each = append(each, WhatIfReport{Removed: elem, Unreachable: unreachableUnder(single, base)})
+	}
+	for _, combo := range combos {
+		single := &Constraints{ExcludedCombos: map[string]bool{combo: true}}
+		each = append(each, WhatIfReport{Removed: combo, Unreachable: unreachableUnder(single, base)})
+	}
+	return combined, each
+}
+
+func handleWhatIf(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cons, err := ParseConstraints(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(cons.ExcludedElements) == 0 && len(cons.ExcludedCombos) == 0 {
+		http.Error(w, "exclude or exclude_combo parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	var response struct {
+		Unreachable []string       `json:"unreachable"`
+		Count       int            `json:"count"`
+		Each        []WhatIfReport `json:"each"`
+	}
+	response.Unreachable, response.Each = WhatIf(cons)
+	response.Count = len(response.Unreachable)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// benchAlgorithms are the searches the bench command can run. "multiple" is
+// FindMultipleRecipes with BFS ingredient searches.
+var benchAlgorithms = []string{"bfs", "dfs", "bidirectional", "multiple"}
+
+// BenchResult is one search of one element. Steps and Depth describe the
+// shallowest recipe found; Allocs and Bytes are the heap allocations the
+// search made.
+type BenchResult struct {
+	Algorithm string
+	Element   string
+	Tier      int
+	Found     bool
+	Time      time.Duration
+	Visited   int
+	Steps     int
+	Depth     int
+	Recipes   int
+	Allocs    uint64
+	Bytes     uint64
+}
+
+var benchColumns = []string{"algorithm", "element", "tier", "found", "time_ns", "visited", "steps", "depth", "recipes", "allocs", "bytes"}
+
+// benchElements returns every element of the dataset, basic ones included,
+// in name order.
+func benchElements() []string {
+	seen := make(map[string]bool)
+	for elem := range tierMap {
+		seen[elem] = true
+	}
+	for _, combos := range combinations {
+		for _, c := range combos {
+			seen[c.Left] = true
+			seen[c.Right] = true
+		}
+	}
+	elements := make([]string, 0, len(seen))
+	for elem := range seen {
+		elements = append(elements, elem)
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+// benchSearch runs algorithm once on element, bypassing the solution index,
+// and returns the recipes and visited count. The caller disables the recipe
+// cache.
+func benchSearch(algorithm, element string, maxRecipes int, cons *Constraints) ([]*Node, int, error) {
+	if algorithm == "multiple" {
+		return findMultipleRecipes(context.Background(), element, maxRecipes, maxRecipes, "bfs", cons, nil)
+	}
+	result, visited, err := searchSingleRecipe(context.Background(), element, algorithm, cons, nil)
+	if result == nil {
+		return nil, visited, err
+	}
+	return []*Node{result}, visited, err
+}
+
+// RunBenchmarks runs every algorithm on every element runs times and keeps
+// the fastest run of each. The recipe cache is disabled and emptied meanwhile,
+// so every run does the full search.
+func RunBenchmarks(algorithms, elements []string, maxRecipes, runs int, cons *Constraints) ([]BenchResult, error) {
+	defer func(capacity int64) { RecipeCacheBytes = capacity }(RecipeCacheBytes)
+	RecipeCacheBytes = 0
+	recipeCache.Reset()
+
+	results := make([]BenchResult, 0, len(algorithms)*len(elements))
+	for _, algorithm := range algorithms {
+		for _, element := range elements {
+			result := BenchResult{Algorithm: algorithm, Element: element, Tier: tierMap[element]}
+			for run := 0; run < max(runs, 1); run++ {
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				start := time.Now()
+				nodes, visited, err := benchSearch(algorithm, element, maxRecipes, cons)
+				elapsed := time.Since(start)
+				runtime.ReadMemStats(&after)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %w", algorithm, element, err)
+				}
+
+				if run > 0 && elapsed >= result.Time {
+					continue
+				}
+				result.Time = elapsed
+				result.Visited = visited
+				result.Recipes = len(nodes)
+				result.Found = len(nodes) > 0
+				result.Allocs = after.Mallocs - before.Mallocs
+				result.Bytes = after.TotalAlloc - before.TotalAlloc
+				result.Steps, result.Depth = 0, 0
+				for i, node := range nodes {
+					if depth := treeDepth(node); i == 0 || depth < result.Depth {
+						result.Depth = depth
+						result.Steps = len(convertRecipeToPath(node))
+					}
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// WriteBenchCSV writes one row per result, with a header row.
+func WriteBenchCSV(w io.Writer, results []BenchResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write(benchColumns)
+	for _, r := range results {
+		cw.Write([]string{
+			r.Algorithm,
+			r.Element,
+			strconv.Itoa(r.Tier),
+			strconv.FormatBool(r.Found),
+			strconv.FormatInt(r.Time.Nanoseconds(), 10),
+			strconv.Itoa(r.Visited),
+			strconv.Itoa(r.Steps),
+			strconv.Itoa(r.Depth),
+			strconv.Itoa(r.Recipes),
+			strconv.FormatUint(r.Allocs, 10),
+			strconv.FormatUint(r.Bytes, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadBenchCSV reads results written by WriteBenchCSV.
+func ReadBenchCSV(r io.Reader) ([]BenchResult, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || len(rows[0]) != len(benchColumns) || rows[0][0] != benchColumns[0] {
+		return nil, fmt.Errorf("not a bench report")
+	}
+
+	results := make([]BenchResult, 0, len(rows)-1)
+	for line, row := range rows[1:] {
+		numbers := make([]int64, len(row))
+		for i := 2; i < len(row); i++ {
+			if i == 3 {
+				continue
+			}
+			n, err := strconv.ParseInt(row[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %v", line+2, benchColumns[i], err)
+			}
+			numbers[i] = n
+		}
+		found, err := strconv.ParseBool(row[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: found: %v", line+2, err)
+		}
+		results = append(results, BenchResult{
+			Algorithm: row[0],
+			Element:   row[1],
+			Tier:      int(numbers[2]),
+			Found:     found,
+			Time:      time.Duration(numbers[4]),
+			Visited:   int(numbers[5]),
+			Steps:     int(numbers[6]),
+			Depth:     int(numbers[7]),
+			Recipes:   int(numbers[8]),
+			Allocs:    uint64(numbers[9]),
+			Bytes:     uint64(numbers[10]),
+		})
+	}
+	return results, nil
+}
+
+// BenchRegression is one metric of one search that got worse than in the
+// baseline.
+type BenchRegression struct {
+	Algorithm string
+	Element   string
+	Metric    string
+	Baseline  string
+	Current   string
+}
+
+// CompareBench compares current with baseline, search by search. A search
+// regresses when it no longer finds a recipe, when its recipe gets longer,
+// or when its time, visited count or allocations grow by more than
+// threshold (0.2 is 20%). Time differences below noise are ignored, since
+// the fastest searches take microseconds. Searches missing from either
+// report are not compared.
+func CompareBench(baseline, current []BenchResult, threshold float64, noise time.Duration) []BenchRegression {
+	type key struct{ algorithm, element string }
+	base := make(map[key]BenchResult, len(baseline))
+	for _, r := range baseline {
+		base[key{r.Algorithm, r.Element}] = r
+	}
+	worse := func(before, after float64) bool {
+		return after > before*(1+threshold)
+	}
+
+	var regressions []BenchRegression
+	for _, r := range current {
+		b, ok := base[key{r.Algorithm, r.Element}]
+		if !ok {
+			continue
+		}
+		flag := func(metric, before, after string) {
+			regressions = append(regressions, BenchRegression{r.Algorithm, r.Element, metric, before, after})
+		}
+		if b.Found && !r.Found {
+			flag("found", "true", "false")
+			continue
+		}
+		if !b.Found || !r.Found {
+			continue
+		}
+		if r.Steps > b.Steps {
+			flag("steps", strconv.Itoa(b.Steps), strconv.Itoa(r.Steps))
+		}
+		if r.Time-b.Time > noise && worse(float64(b.Time), float64(r.Time)) {
+			flag("time", b.Time.String(), r.Time.String())
+		}
+		if worse(float64(b.Visited), float64(r.Visited)) {
+			flag("visited", strconv.Itoa(b.Visited), strconv.Itoa(r.Visited))
+		}
+		if worse(float64(b.Allocs), float64(r.Allocs)) {
+			flag("allocs", strconv.FormatUint(b.Allocs, 10), strconv.FormatUint(r.Allocs, 10))
+		}
+	}
+	return regressions
+}
+
+// WriteBenchMarkdown writes a summary table with one row per algorithm,
+// followed by the regressions, if a baseline was compared.
+func WriteBenchMarkdown(w io.Writer, results []BenchResult, regressions []BenchRegression, baseline string) {
+	byAlgorithm := make(map[string][]BenchResult)
+	var algorithms []string
+	for _, r := range results {
+		if byAlgorithm[r.Algorithm] == nil {
+			algorithms = append(algorithms, r.Algorithm)
+		}
+		byAlgorithm[r.Algorithm] = append(byAlgorithm[r.Algorithm], r)
+	}
+
+	fmt.Fprintln(w, "| Algorithm | Elements | Found | Total time | Mean time | Median time | p95 time | Max time | Mean visited | Mean steps | Mean depth | Mean allocs |")
+	fmt.Fprintln(w, "|-----------|---------:|------:|-----------:|----------:|------------:|---------:|---------:|-------------:|-----------:|-----------:|------------:|")
+	for _, algorithm := range algorithms {
+		rows := byAlgorithm[algorithm]
+		times := make([]time.Duration, len(rows))
+		var total time.Duration
+		found, visited, steps, depth, allocs := 0, 0, 0, 0, uint64(0)
+		for i, r := range rows {
+			times[i] = r.Time
+			total += r.Time
+			visited += r.Visited
+			allocs += r.Allocs
+			if r.Found {
+				found++
+				steps += r.Steps
+				depth += r.Depth
+			}
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		percentile := func(p float64) time.Duration {
+			return times[int(math.Ceil(p*float64(len(times))))-1]
+		}
+		perFound := func(sum int) string {
+			if found == 0 {
+				return "-"
+			}
+			return strconv.FormatFloat(float64(sum)/float64(found), 'f', 1, 64)
+		}
+		fmt.Fprintf(w, "| %s | %d | %d | %s | %s | %s | %s | %s | %.1f | %s | %s | %.0f |\n",
+			algorithm, len(rows), found,
+			total.Round(time.Microsecond), (total / time.Duration(len(rows))).Round(time.Microsecond),
+			percentile(0.5).Round(time.Microsecond), percentile(0.95).Round(time.Microsecond), times[len(times)-1].Round(time.Microsecond),
+			float64(visited)/float64(len(rows)), perFound(steps), perFound(depth), float64(allocs)/float64(len(rows)))
+	}
+
+	if baseline == "" {
+		return
+	}
+	fmt.Fprintf(w, "\n%d regression(s) against %s\n", len(regressions), baseline)
+	if len(regressions) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\n| Algorithm | Element | Metric | Baseline | Current |")
+	fmt.Fprintln(w, "|-----------|---------|--------|---------:|--------:|")
+	for _, r := range regressions {
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s |\n", r.Algorithm, r.Element, r.Metric, r.Baseline, r.Current)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+// BenchmarkAlgorithms runs each algorithm on every element of the dataset
+// per operation, with the recipe cache disabled. Besides time and
+// allocations it reports the nodes visited and elements solved per
+// operation. Use the bench command for per-element reports.
+func BenchmarkAlgorithms(b *testing.B) {
+	loadDataset(b)
+	defer func(capacity int64) { RecipeCacheBytes = capacity }(RecipeCacheBytes)
+	RecipeCacheBytes = 0
+	recipeCache.Reset()
+
+	elements := benchElements()
+	cons := &Constraints{Policy: strictTierPolicy{}}
+	for _, algorithm := range benchAlgorithms {
+		b.Run(algorithm, func(b *testing.B) {
+			b.ReportAllocs()
+			visited, found := 0, 0
+			for i := 0; i < b.N; i++ {
+				for _, element := range elements {
+					nodes, v, err := benchSearch(algorithm, element, 10, cons)
+					if err != nil {
+						b.Fatal(err)
+					}
+					visited += v
+					if len(nodes) > 0 {
+						found++
+					}
+				}
+			}
+			b.ReportMetric(float64(visited)/float64(b.N), "visited/op")
+			b.ReportMetric(float64(found)/float64(b.N), "found/op")
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// bidirectionalSearch runs the AND-OR bidirectional search shared by
+// FindRecipeBidirectional and FindMultipleRecipesBidirectional.
+//
+// The forward half builds recipes bottom-up from the basic elements, using
+// ingredientMap to find the combinations each solved element takes part in.
+// The backward half expands the target top-down and remembers, for every
+// ingredient it reaches, which combinations need it (parents), so the
+// partial recipe structure above the frontier is kept. Whenever an element
+// gets a recipe, either side, its backward parents are retried at once: the
+// forward subtree is spliced into the backward structure at that meeting
+// element, and completions travel upward until they reach the target.
+//
+// The search stops when ctx is done, and keeps at most limit recipes per
+// element when limit > 0. visited counts both frontiers.
+type bidirectionalSearch struct {
+	ctx        context.Context
+	target     string
+	cons       *Constraints
+	exhaustive bool
+	limit      int
+	visited    int
+	observe    SearchObserver
+
+	recipes       map[string][]*Node
+	forwardQueue  []string
+	backwardQueue []string
+	backwardSeen  map[string]bool
+	parents       map[string][]Combination
+
+	finished map[Combination]bool
+	seen     map[string]bool
+	results  []*Node
+
+	backwardTurn     bool
+	stopped          bool
+	forwardExpanded  []string
+	backwardExpanded []string
+}
+
+func newBidirectionalSearch(ctx context.Context, target string, cons *Constraints, exhaustive bool, limit int, observe SearchObserver) *bidirectionalSearch {
+	s := &bidirectionalSearch{
+		ctx:           ctx,
+		target:        target,
+		cons:          cons,
+		exhaustive:    exhaustive,
+		limit:         limit,
+		observe:       observe,
+		visited:       1,
+		recipes:       make(map[string][]*Node),
+		backwardQueue: []string{target},
+		backwardSeen:  map[string]bool{target: true},
+		parents:       make(map[string][]Combination),
+		finished:      make(map[Combination]bool),
+		seen:          make(map[string]bool),
+	}
+
+	for _, b := range getSortedBasicElements() {
+		if s.cons.AllowsElement(b) {
+			s.solve(b, []*Node{{Element: b}})
+		}
+	}
+	return s
+}
+
+// solve records the recipes of a newly solved element, queues it for forward
+// expansion and splices it into every backward combination waiting on it.
+func (s *bidirectionalSearch) solve(elem string, nodes []*Node) {
+	if _, solved := s.recipes[elem]; solved {
+		return
+	}
+	s.recipes[elem] = nodes
+	s.forwardQueue = append(s.forwardQueue, elem)
+	s.visited++
+	s.observe.emit(SearchEvent{Type: EventPush, Search: s.target, Element: elem, Direction: "forward"})
+
+	if len(s.parents[elem]) > 0 {
+		s.observe.emit(SearchEvent{Type: EventMeet, Search: s.target, Element: elem})
+	}
+	for _, c := range s.parents[elem] {
+		s.complete(c)
+	}
+}
+
+// complete builds recipes for c.Root from c once both ingredients are solved.
+// Completions of the target itself become results instead.
+func (s *bidirectionalSearch) complete(c Combination) {
+	if s.finished[c] || !s.cons.Allows(c) {
+		return
+	}
+	left, right := s.recipes[c.Left], s.recipes[c.Right]
+	if len(left) == 0 || len(right) == 0 {
+		return
+	}
+	if _, solved := s.recipes[c.Root]; solved {
+		return
+	}
+	s.finished[c] = true
+	s.observe.emit(SearchEvent{Type: EventRecipe, Search: s.target, Element: c.Root, Left: c.Left, Right: c.Right})
+
+	var nodes []*Node
+	for _, l := range left {
+		for _, r := range right {
+			if s.limit > 0 && len(nodes) >= s.limit {
+				break
+			}
+			nodes = append(nodes, &Node{Element: c.Root, Left: l, Right: r})
+		}
+	}
+
+	if c.Root != s.target {
+		s.solve(c.Root, nodes)
+		return
+	}
+
+	for _, node := range nodes {
+		if s.limit > 0 && len(s.results) >= s.limit {
+			break
+		}
+		signature := serializeTree(node)
+		if !s.seen[signature] {
+			s.seen[signature] = true
+			s.results = append(s.results, node)
+		}
+	}
+}
+
+func (s *bidirectionalSearch) stepForward() {
+	current := s.forwardQueue[0]
+	s.forwardQueue = s.forwardQueue[1:]
+	s.forwardExpanded = append(s.forwardExpanded, current)
+	s.observe.emit(SearchEvent{Type: EventVisit, Search: s.target, Element: current, Direction: "forward", Visited: s.visited, Frontier: len(s.forwardQueue)})
+
+	for _, c := range s.cons.ordered(ingredientMap[current]) {
+		s.complete(c)
+	}
+}
+
+// stepBackward expands the next backward element and reports whether it
+// needed expanding; elements solved in the meantime are dropped.
+func (s *bidirectionalSearch) stepBackward() bool {
+	current := s.backwardQueue[0]
+	s.backwardQueue = s.backwardQueue[1:]
+	if _, solved := s.recipes[current]; solved {
+		return false
+	}
+	s.backwardExpanded = append(s.backwardExpanded, current)
+	s.observe.emit(SearchEvent{Type: EventVisit, Search: s.target, Element: current, Direction: "backward", Visited: s.visited, Frontier: len(s.backwardQueue)})
+
+	for _, c := range s.cons.ordered(combinations[current]) {
+		if reason := s.cons.rejection(c); reason != "" {
+			s.observe.emit(SearchEvent{Type: EventSkip, Search: s.target, Element: current, Left: c.Left, Right: c.Right, Reason: reason})
+			continue
+		}
+		for _, ingredient := range []string{c.Left, c.Right} {
+			s.parents[ingredient] = append(s.parents[ingredient], c)
+			if !s.backwardSeen[ingredient] {
+				s.backwardSeen[ingredient] = true
+				s.backwardQueue = append(s.backwardQueue, ingredient)
+				s.visited++
+				s.observe.emit(SearchEvent{Type: EventPush, Search: s.target, Element: ingredient, Parent: current, Direction: "backward"})
+			}
+		}
+		s.complete(c)
+	}
+	return true
+}
+
+func (s *bidirectionalSearch) done() bool {
+	if s.ctx.Err() != nil {
+		return true
+	}
+	if !s.exhaustive {
+		return len(s.results) > 0
+	}
+	return s.limit > 0 && len(s.results) >= s.limit
+}
+
+// Step makes the next expansion, alternating between the forward and the
+// backward frontier, and reports whether there is more to do. The search
+// ends when the target is solved, or, when exhaustive, when both frontiers
+// are empty.
+func (s *bidirectionalSearch) Step() bool {
+	for !s.stopped {
+		if !s.backwardTurn {
+			if s.over() {
+				s.stopped = true
+				break
+			}
+			s.backwardTurn = true
+			if len(s.forwardQueue) > 0 {
+				s.stepForward()
+				return true
+			}
+		} else {
+			s.backwardTurn = false
+			if len(s.backwardQueue) > 0 && s.stepBackward() {
+				// The forward turn starts by checking this, so stopping
+				// here already changes nothing but the snapshot.
+				s.stopped = s.over()
+				return !s.stopped
+			}
+		}
+	}
+	return false
+}
+
+func (s *bidirectionalSearch) over() bool {
+	return len(s.forwardQueue) == 0 && len(s.backwardQueue) == 0 || s.done()
+}
+
+func (s *bidirectionalSearch) run() []*Node {
+	for s.Step() {
+	}
+	return s.results
+}
+
+// Result returns the first recipe found, or nil.
+func (s *bidirectionalSearch) Result() *Node {
+	if len(s.results) == 0 {
+		return nil
+	}
+	return s.results[0]
+}
+
+// Snapshot reports both frontiers and the elements each side expanded.
+func (s *bidirectionalSearch) Snapshot() SearchSnapshot {
+	return SearchSnapshot{
+		Frontier:         append([]string{}, s.forwardQueue...),
+		BackwardFrontier: append([]string{}, s.backwardQueue...),
+		Visited:          append([]string{}, s.forwardExpanded...),
+		BackwardVisited:  append([]string{}, s.backwardExpanded...),
+		VisitedCount:     s.visited,
+		Done:             s.stopped,
+		Found:            len(s.results) > 0,
+	}
+}
+
+func FindRecipeBidirectional(target string, cons *Constraints) *Node {
+	result, visited := findRecipeBidirectional(context.Background(), target, cons, nil)
+	BidirectionalVisitedCount = visited
+	return result
+}
+
+// findRecipeBidirectional is FindRecipeBidirectional with its visited count
+// returned instead of stored globally, so calls can run concurrently.
+// Progress is reported to observe, and the search stops early when ctx is
+// done.
+func findRecipeBidirectional(ctx context.Context, target string, cons *Constraints, observe SearchObserver) (*Node, int) {
+	log := newSearchLog(ctx, "bidirectional", "single", target)
+	log.debug("search started")
+
+	if !cons.AllowsElement(target) {
+		log.debug("target excluded")
+		return nil, 0
+	}
+	if isBasic(target) {
+		log.debug("target is basic")
+		return &Node{Element: target}, 1
+	}
+	if _, exists := combinations[target]; !exists {
+		log.debug("target not in dataset")
+		return nil, 0
+	}
+
+	search := newBidirectionalSearch(ctx, target, cons, false, 0, observe)
+	results := search.run()
+	log.debug("search finished", slog.Bool("found", len(results) > 0), slog.Int("visited", search.visited))
+	if len(results) == 0 {
+		return nil, search.visited
+	}
+	return results[0], search.visited
+}
+
+func FindMultipleRecipesBidirectional(target string, cons *Constraints) []*Node {
+	results, visited := multipleRecipesBidirectional(context.Background(), target, cons, 0, nil)
+	BidirectionalVisitedCount = visited
+	return results
+}
+
+// multipleRecipesBidirectional is FindMultipleRecipesBidirectional with its
+// visited count returned instead of stored globally, so calls can run
+// concurrently. Progress is reported to observe.
+func multipleRecipesBidirectional(ctx context.Context, target string, cons *Constraints, limit int, observe SearchObserver) ([]*Node, int) {
+	log := newSearchLog(ctx, "bidirectional", "multiple", target)
+	log.debug("search started")
+
+	if !cons.AllowsElement(target) {
+		log.debug("target excluded")
+		return nil, 0
+	}
+	if isBasic(target) {
+		log.debug("target is basic")
+		return []*Node{{Element: target}}, 1
+	}
+	if _, exists := combinations[target]; !exists {
+		log.debug("target not in dataset")
+		return nil, 0
+	}
+
+	search := newBidirectionalSearch(ctx, target, cons, true, limit, observe)
+	results := search.run()
+	log.debug("search finished", slog.Int("recipes", len(results)), slog.Int("visited", search.visited))
+	return results, search.visited
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"unsafe"
+)
+
+// datasetHash identifies the loaded combinations file. Cached results are
+// tagged with it, so loading a different file invalidates them.
+var datasetHash string
+
+// RecipeCacheBytes is the memory budget of recipeCache, set from the
+// RECIPE_CACHE_MB environment variable. Zero disables the cache.
+var RecipeCacheBytes int64 = 64 << 20
+
+// cachedRecipes is one search result: the recipes found, in order, and the
+// visited count the search reported. The element stats computed without an
+// index are cached the same way, in ranks and counts.
+type cachedRecipes struct {
+	nodes   []*Node
+	visited int
+	ranks   map[string]int
+	counts  map[string]*big.Int
+}
+
+type cacheItem struct {
+	key     string
+	dataset string
+	value   cachedRecipes
+	size    int64
+}
+
+// CacheStats is a snapshot of the recipe cache counters.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Capacity  int64  `json:"capacity"`
+	Dataset   string `json:"dataset"`
+}
+
+// recipeLRU is a process-wide cache of search results shared by every
+// request. It evicts the least recently used entries once their estimated
+// size exceeds RecipeCacheBytes. Cached trees are shared between callers
+// and must not be modified.
+type recipeLRU struct {
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+	bytes int64
+	stats CacheStats
+}
+
+var recipeCache = &recipeLRU{
+	items: make(map[string]*list.Element),
+	order: list.New(),
+}
+
+// recipeCacheKey builds the cache key of a search: which kind of result it
+// is, the algorithm, the element, the result limit and the constraints.
+func recipeCacheKey(kind, algorithm, elem string, limit int, cons *Constraints) string {
+	return kind + "|" + algorithm + "|" + elem + "|" + strconv.Itoa(limit) + "|" + cons.key()
+}
+
+// Get returns the cached result for key if there is one for the loaded
+// dataset. With RecipeCacheBytes at zero the cache is disabled and Get
+// always misses, even for entries stored before it was disabled.
+func (c *recipeLRU) Get(key string) (cachedRecipes, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if RecipeCacheBytes <= 0 {
+		c.stats.Misses++
+		return cachedRecipes{}, false
+	}
+	if e, ok := c.items[key]; ok {
+		item := e.Value.(*cacheItem)
+		if item.dataset == datasetHash {
+			c.order.MoveToFront(e)
+			c.stats.Hits++
+			return item.value, true
+		}
+		c.remove(e)
+	}
+	c.stats.Misses++
+	return cachedRecipes{}, false
+}
+
+// Put stores value under key, evicting old entries to stay within
+// RecipeCacheBytes. Values larger than the whole budget are not stored.
+func (c *recipeLRU) Put(key string, value cachedRecipes) {
+	size := int64(len(key)) + treesSize(value.nodes) + statsSize(value.ranks, value.counts)
+	if size > RecipeCacheBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	c.items[key] = c.order.PushFront(&cacheItem{key: key, dataset: datasetHash, value: value, size: size})
+	c.bytes += size
+	for c.bytes > RecipeCacheBytes {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Reset drops every entry. The hit, miss and eviction counters are kept.
+func (c *recipeLRU) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.bytes = 0
+}
+
+func (c *recipeLRU) remove(e *list.Element) {
+	item := e.Value.(*cacheItem)
+	c.order.Remove(e)
+	delete(c.items, item.key)
+	c.bytes -= item.size
+}
+
+// Stats returns the current counters.
+func (c *recipeLRU) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.items)
+	stats.Bytes = c.bytes
+	stats.Capacity = RecipeCacheBytes
+	stats.Dataset = datasetHash
+	return stats
+}
+
+// treesSize estimates the memory held by trees. Subtrees are often shared
+// between recipes, so each node is counted once.
+func treesSize(trees []*Node) int64 {
+	seen := make(map[*Node]bool)
+	var size int64
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil || seen[n] {
+			return
+		}
+		seen[n] = true
+		size += int64(unsafe.Sizeof(*n)) + int64(len(n.Element))
+		walk(n.Left)
+		walk(n.Right)
+	}
+	for _, n := range trees {
+		size += int64(unsafe.Sizeof(n))
+		walk(n)
+	}
+	return size
+}
+
+// statsSize estimates the bytes held by cached element ranks and counts.
+func statsSize(ranks map[string]int, counts map[string]*big.Int) int64 {
+	var size int64
+	for elem := range ranks {
+		size += int64(len(elem)) + int64(unsafe.Sizeof(0))
+	}
+	for elem, n := range counts {
+		size += int64(len(elem)) + int64(unsafe.Sizeof(*n)) + int64(len(n.Bits()))*int64(unsafe.Sizeof(big.Word(0)))
+	}
+	return size
+}
+
+func handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recipeCache.Stats())
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runCLI runs one of the offline subcommands against the loaded dataset
+// instead of starting the HTTP server.
+func runCLI(args []string) error {
+	switch args[0] {
+	case "mandatory":
+		return runMandatory(args[1:])
+	case "whatif":
+		return runWhatIf(args[1:])
+	case "cycles":
+		return runCycles(args[1:])
+	case "index":
+		return runIndex(args[1:])
+	case "trace":
+		return runTrace(args[1:])
+	case "replay":
+		return runReplay(args[1:])
+	case "bench":
+		return runBench(args[1:])
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+func runMandatory(args []string) error {
+	fs := flag.NewFlagSet("mandatory", flag.ContinueOnError)
+	without := fs.String("without", "", "comma-separated elements to check for avoidability")
+	policyName := fs.String("policy", "", "validity policy: strict, lte, none or whitelist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	policy, err := PolicyByName(*policyName)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mandatory [-without A,B] [-policy name] <element>")
+	}
+
+	element := fs.Arg(0)
+	if _, exists := tierMap[element]; !exists {
+		return fmt.Errorf("element not found: %s", element)
+	}
+
+	report := AnalyzeIngredients(element, &Constraints{Policy: policy})
+	if !report.Craftable {
+		fmt.Printf("%s has no legal recipe\n", element)
+		return nil
+	}
+
+	fmt.Printf("%s (tier %d)\n", element, tierMap[element])
+	fmt.Printf("Mandatory (%d): %s\n", len(report.Mandatory), strings.Join(report.Mandatory, ", "))
+	fmt.Printf("Avoidable (%d): %s\n", len(report.Avoidable), strings.Join(report.Avoidable, ", "))
+	for _, avoid := range splitList(*without) {
+		if report.CanAvoid(avoid) {
+			fmt.Printf("Without %s: possible\n", avoid)
+		} else {
+			fmt.Printf("Without %s: not possible\n", avoid)
+		}
+	}
+	return nil
+}
+
+func runWhatIf(args []string) error {
+	fs := flag.NewFlagSet("whatif", flag.ContinueOnError)
+	exclude := fs.String("exclude", "", "comma-separated elements to remove")
+	excludeCombo := fs.String("exclude-combo", "", "comma-separated ingredient pairs to remove, e.g. Fire+Earth")
+	all := fs.Bool("all", false, "rank every element by how many elements its removal makes unreachable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *all {
+		base := craftableSet(nil)
+		type impact struct {
+			element string
+			lost    int
+		}
+		impacts := []impact{}
+		for elem := range tierMap {
+			single := &Constraints{ExcludedElements: map[string]bool{elem: true}}
+			if lost := len(unreachableUnder(single, base)); lost > 0 {
+				impacts = append(impacts, impact{elem, lost})
+			}
+		}
+		sort.Slice(impacts, func(i, j int) bool {
+			if impacts[i].lost != impacts[j].lost {
+				return impacts[i].lost > impacts[j].lost
+			}
+			return impacts[i].element < impacts[j].element
+		})
+		for _, im := range impacts {
+			fmt.Printf("%-24s %d\n", im.element, im.lost)
+		}
+		return nil
+	}
+
+	cons, err := ParseConstraints(url.Values{
+		"exclude":       {*exclude},
+		"exclude_combo": {*excludeCombo},
+	})
+	if err != nil {
+		return err
+	}
+	if len(cons.ExcludedElements) == 0 && len(cons.ExcludedCombos) == 0 {
+		return fmt.Errorf("usage: whatif [-exclude A,B] [-exclude-combo A+B] | whatif -all")
+	}
+
+	combined, each := WhatIf(cons)
+	for _, report := range each {
+		fmt.Printf("Without %s (%d): %s\n", report.Removed, len(report.Unreachable), strings.Join(report.Unreachable, ", "))
+	}
+	fmt.Printf("Combined (%d): %s\n", len(combined), strings.Join(combined, ", "))
+	return nil
+}
+
+func runCycles(args []string) error {
+	fs := flag.NewFlagSet("cycles", flag.ContinueOnError)
+	limit := fs.Int("limit", 0, "maximum number of cycles to list (0 lists all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report := AnalyzeCycles(*limit)
+	fmt.Printf("Cyclic components: %d\n", len(report.Components))
+	for _, component := range report.Components {
+		fmt.Printf("  (%d) %s\n", len(component), strings.Join(component, ", "))
+	}
+
+	fmt.Printf("Cycles: %d\n", len(report.Cycles))
+	for _, cycle := range report.Cycles {
+		fmt.Printf("  %s -> %s [loops under: %s]\n", strings.Join(cycle.Elements, " -> "), cycle.Elements[0],
+			strings.Join(cycle.LoopsUnder, ", "))
+	}
+	if report.Truncated {
+		fmt.Println("  ...")
+	}
+	return nil
+}
+
+func runIndex(args []string) error {
+	fs := flag.NewFlagSet("index", flag.ContinueOnError)
+	out := fs.String("out", "index.json", "file to write the solution index to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	index := BuildIndex()
+	if err := WriteIndex(index, *out); err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d elements into %s\n", len(index.Elements), *out)
+	return nil
+}
+
+func runTrace(args []string) error {
+	fs := flag.NewFlagSet("trace", flag.ContinueOnError)
+	mode := fs.String("mode", "bfs", "algorithm: bfs, dfs or bidirectional")
+	recipeMode := fs.String("recipe-mode", "single", "single or multiple")
+	maxRecipes := fs.String("max-recipes", "", "recipes to find in multiple mode")
+	policyName := fs.String("policy", "", "validity policy: strict, lte, none or whitelist")
+	format := fs.String("format", "ndjson", "json or ndjson")
+	out := fs.String("out", "", "file to write the trace to instead of stdout")
+	timing := fs.Bool("timing", true, "record timestamps; without them a trace is reproducible byte for byte")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: trace [-mode m] [-recipe-mode r] [-max-recipes n] [-policy p] [-format f] [-out file] <element>")
+	}
+	if *format != "json" && *format != "ndjson" {
+		return fmt.Errorf("invalid format: %s", *format)
+	}
+
+	query := url.Values{
+		"element":     {fs.Arg(0)},
+		"mode":        {*mode},
+		"recipe_mode": {*recipeMode},
+	}
+	if *maxRecipes != "" {
+		query.Set("max_recipes", *maxRecipes)
+	}
+	if *policyName != "" {
+		query.Set("policy", *policyName)
+	}
+	if !*timing {
+		query.Set("timing", "false")
+	}
+	req, err := parseSearchRequest(query)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	trace, err := RecordSearch(req, query)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		return json.NewEncoder(w).Encode(trace)
+	}
+	return WriteTraceNDJSON(w, trace)
+}
+
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	delay := fs.Duration("delay", 0, "pause between events, e.g. 50ms")
+	realtime := fs.Bool("realtime", false, "pause between events as long as the recorded search did")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: replay [-delay d] [-realtime] <trace file>")
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	trace, err := ReadTrace(file)
+	if err != nil {
+		return err
+	}
+	ReplayTrace(os.Stdout, trace, *delay, *realtime)
+	return nil
+}
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	algorithms := fs.String("algorithms", strings.Join(benchAlgorithms, ","), "comma-separated algorithms: bfs, dfs, bidirectional, multiple")
+	elements := fs.String("elements", "", "comma-separated elements to search (default every element)")
+	maxRecipes := fs.Int("max-recipes", 10, "recipes to find with the multiple algorithm")
+	runs := fs.Int("runs", 1, "runs per search; the fastest is kept")
+	policyName := fs.String("policy", "", "validity policy: strict, lte, none or whitelist")
+	csvOut := fs.String("csv", "", "file to write the per-search CSV report to")
+	markdownOut := fs.String("markdown", "", "file to write the Markdown summary to instead of stdout")
+	baseline := fs.String("baseline", "", "CSV report of an earlier run to check for regressions")
+	threshold := fs.Float64("threshold", 0.2, "relative growth of time, visited or allocs that counts as a regression")
+	noise := fs.Duration("noise", time.Millisecond, "time growth to ignore regardless of threshold")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: bench [-algorithms a,b] [-elements A,B] [-runs n] [-csv file] [-markdown file] [-baseline file]")
+	}
+
+	policy, err := PolicyByName(*policyName)
+	if err != nil {
+		return err
+	}
+	selected := splitList(*algorithms)
+	for _, algorithm := range selected {
+		known := false
+		for _, name := range benchAlgorithms {
+			known = known || algorithm == name
+		}
+		if !known {
+			return fmt.Errorf("unknown algorithm: %s", algorithm)
+		}
+	}
+	targets := splitList(*elements)
+	if len(targets) == 0 {
+		targets = benchElements()
+	}
+
+	var base []BenchResult
+	if *baseline != "" {
+		file, err := os.Open(*baseline)
+		if err != nil {
+			return err
+		}
+		base, err = ReadBenchCSV(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", *baseline, err)
+		}
+	}
+
+	results, err := RunBenchmarks(selected, targets, *maxRecipes, *runs, &Constraints{Policy: policy})
+	if err != nil {
+		return err
+	}
+
+	if *csvOut != "" {
+		file, err := os.Create(*csvOut)
+		if err != nil {
+			return err
+		}
+		err = WriteBenchCSV(file, results)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var regressions []BenchRegression
+	if base != nil {
+		regressions = CompareBench(base, results, *threshold, *noise)
+	}
+	var w io.Writer = os.Stdout
+	if *markdownOut != "" {
+		file, err := os.Create(*markdownOut)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	WriteBenchMarkdown(w, results, regressions, *baseline)
+
+	if len(regressions) > 0 {
+		return fmt.Errorf("%d regression(s) against %s", len(regressions), *baseline)
+	}
+	return nil
+}
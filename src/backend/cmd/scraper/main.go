@@ -0,0 +1,9 @@
+package main
+
+import (
+	"littlealchemy/scraper"
+)
+
+func main() {
+	scraper.Scraper()
+}
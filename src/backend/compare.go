@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CompareResult is one algorithm's answer in /compare. Steps is the number
+// of combinations in the recipe and Depth the number on its longest chain;
+// Visited is the algorithm's own visited count. Time is the algorithm's wall
+// time in milliseconds, left out with timing=false.
+type CompareResult struct {
+	Algorithm string   `json:"algorithm"`
+	Found     bool     `json:"found"`
+	Recipe    []Step   `json:"recipe"`
+	RecipeID  string   `json:"recipeId,omitempty"`
+	Steps     int      `json:"steps"`
+	Depth     int      `json:"depth"`
+	Visited   int      `json:"visited"`
+	Time      *float64 `json:"time,omitempty"`
+}
+
+// CompareResponse is the answer of /compare. Winners maps each metric
+// (steps, depth, visited and time) to the algorithms with the lowest value
+// among those that found a recipe; ties list every algorithm sharing it.
+type CompareResponse struct {
+	Target struct {
+		Element string `json:"element"`
+		Tier    int    `json:"tier"`
+	} `json:"target"`
+	Results []CompareResult     `json:"results"`
+	Winners map[string][]string `json:"winners"`
+}
+
+// CompareAlgorithms runs every single-recipe algorithm on element at once
+// and returns their answers in singleRecipeModes order. The searches run
+// live, bypassing the solution index and recipe cache, and read only the
+// loaded dataset, so they all see the same graph. Each reports its own
+// visited count, so the global counters are left alone. With ids, the
+// recipes are registered and carry their IDs. If a search gives up, the
+// comparison fails with its error, and if ctx is done first, with ctx's.
+func CompareAlgorithms(ctx context.Context, element string, cons *Constraints, timing, ids bool) (*CompareResponse, error) {
+	response := &CompareResponse{Results: make([]CompareResult, len(singleRecipeModes))}
+	response.Target.Element = element
+	response.Target.Tier = tierMap[element]
+
+	errs := make([]error, len(singleRecipeModes))
+	var wg sync.WaitGroup
+	for i, mode := range singleRecipeModes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			result, visited, err := searchSingleRecipe(ctx, element, mode, cons, nil)
+			elapsed := time.Since(start)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			answer := CompareResult{Algorithm: mode, Recipe: []Step{}, Visited: visited}
+			if result != nil {
+				answer.Found = true
+				answer.Recipe = convertRecipeToPath(result)
+				if ids {
+					answer.RecipeID = registry.Register(result)
+				}
+				answer.Steps = len(answer.Recipe)
+				answer.Depth = treeDepth(result) - 1
+			}
+			if timing {
+				milliseconds := float64(elapsed.Microseconds()) / 1000.0
+				answer.Time = &milliseconds
+			}
+			response.Results[i] = answer
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response.Winners = compareWinners(response.Results, timing)
+	return response, nil
+}
+
+func compareWinners(results []CompareResult, timing bool) map[string][]string {
+	metrics := map[string]func(CompareResult) float64{
+		"steps":   func(r CompareResult) float64 { return float64(r.Steps) },
+		"depth":   func(r CompareResult) float64 { return float64(r.Depth) },
+		"visited": func(r CompareResult) float64 { return float64(r.Visited) },
+	}
+	if timing {
+		metrics["time"] = func(r CompareResult) float64 { return *r.Time }
+	}
+
+	winners := make(map[string][]string)
+	for metric, value := range metrics {
+		best := 0.0
+		for _, r := range results {
+			if !r.Found {
+				continue
+			}
+			switch v := value(r); {
+			case winners[metric] == nil || v < best:
+				best = v
+				winners[metric] = []string{r.Algorithm}
+			case v == best:
+				winners[metric] = append(winners[metric], r.Algorithm)
+			}
+		}
+	}
+	return winners
+}
+
+// handleCompare runs every single-recipe algorithm on one element. It takes
+// element, the constraint parameters of /search, seed, timing and ids.
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	element := query.Get("element")
+	if element == "" {
+		metrics.countCompare(outcomeBadRequest)
+		http.Error(w, "Element parameter is required", http.StatusBadRequest)
+		return
+	}
+	cons, err := ParseConstraints(query)
+	if err == nil {
+		err = parseSeed(query, cons)
+	}
+	if err != nil {
+		metrics.countCompare(outcomeBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := CompareAlgorithms(r.Context(), element, cons, query.Get("timing") != "false", query.Get("ids") != "false")
+	if r.Context().Err() != nil {
+		metrics.countCompare(outcomeCancelled)
+		return
+	}
+	if err != nil {
+		metrics.countCompare(outcomeGaveUp)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	outcome := outcomeNotFound
+	for _, result := range response.Results {
+		if result.Found {
+			outcome = outcomeFound
+		}
+	}
+	metrics.countCompare(outcome)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		requestLogger(r.Context()).Error("encoding comparison", "error", err)
+	}
+}
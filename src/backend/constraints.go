@@ -0,0 +1,441 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Constraints restricts which elements and combinations a search may use on
+// top of the validity policy, and what shape the returned recipe may have.
+// A nil *Constraints only applies the strict tier rule. A nil Policy also
+// means the strict tier rule, and zero limits mean unlimited.
+//
+// Exclusions and MaxTierJump are checked per combination by Allows, so every
+// search honours them while it expands. Include, MaxDepth and MaxSteps only
+// make sense for a whole tree and are checked by Satisfies.
+//
+// Seed only changes tie-breaking: searches try an element's combinations in
+// dataset order, or, with a non-zero Seed, in the order given by ordered.
+type Constraints struct {
+	Policy           ValidityPolicy
+	ExcludedElements map[string]bool
+	ExcludedCombos   map[string]bool
+	Include          []string
+	MaxDepth         int
+	MaxSteps         int
+	MaxTierJump      int
+	Seed             int64
+}
+
+// maxIncluded caps the include list. FindConstrainedRecipe keeps a table
+// entry per subset of it, so every extra element doubles its work.
+const maxIncluded = 6
+
+// comboKey identifies an ingredient pair independently of its order.
+func comboKey(left, right string) string {
+	if left > right {
+		left, right = right, left
+	}
+	return left + "+" + right
+}
+
+// ParseConstraints reads the constraint query parameters. policy names a
+// ValidityPolicy; exclude and include are comma-separated lists of elements,
+// include holding at most maxIncluded of them; exclude_combo is a
+// comma-separated list of ingredient pairs written as Left+Right; max_depth,
+// max_steps and max_tier_jump are positive integers.
+func ParseConstraints(query url.Values) (*Constraints, error) {
+	cons := &Constraints{
+		ExcludedElements: make(map[string]bool),
+		ExcludedCombos:   make(map[string]bool),
+	}
+
+	policy, err := PolicyByName(query.Get("policy"))
+	if err != nil {
+		return nil, err
+	}
+	cons.Policy = policy
+
+	for _, elem := range splitList(query.Get("exclude")) {
+		cons.ExcludedElements[elem] = true
+	}
+	for _, pair := range splitList(query.Get("exclude_combo")) {
+		left, right, ok := strings.Cut(pair, "+")
+		left, right = strings.TrimSpace(left), strings.TrimSpace(right)
+		if !ok || left == "" || right == "" {
+			return nil, fmt.Errorf("invalid exclude_combo: %s", pair)
+		}
+		cons.ExcludedCombos[comboKey(left, right)] = true
+	}
+
+	included := make(map[string]bool)
+	for _, elem := range splitList(query.Get("include")) {
+		if !included[elem] {
+			included[elem] = true
+			cons.Include = append(cons.Include, elem)
+		}
+	}
+	if len(cons.Include) > maxIncluded {
+		return nil, fmt.Errorf("invalid include: at most %d elements", maxIncluded)
+	}
+	sort.Strings(cons.Include)
+
+	limits := []struct {
+		name  string
+		value *int
+	}{
+		{"max_depth", &cons.MaxDepth},
+		{"max_steps", &cons.MaxSteps},
+		{"max_tier_jump", &cons.MaxTierJump},
+	}
+	for _, limit := range limits {
+		str := query.Get(limit.name)
+		if str == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(str)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("invalid %s: %s", limit.name, str)
+		}
+		*limit.value = parsed
+	}
+	return cons, nil
+}
+
+// policy returns the validity policy cons applies.
+func (cons *Constraints) policy() ValidityPolicy {
+	if cons == nil || cons.Policy == nil {
+		return strictTierPolicy{}
+	}
+	return cons.Policy
+}
+
+// AllowsElement reports whether elem may be made or used.
+func (cons *Constraints) AllowsElement(elem string) bool {
+	return cons == nil || !cons.ExcludedElements[elem]
+}
+
+// Allows is the validity filter shared by every search: the combination must
+// pass the validity policy, and neither the elements nor the ingredient pair
+// may be excluded.
+func (cons *Constraints) Allows(c Combination) bool {
+	return cons.rejection(c) == ""
+}
+
+// Reasons a combination fails Allows.
+const (
+	rejectPolicy          = "tier_rule"
+	rejectTierJump        = "max_tier_jump"
+	rejectExcludedElement = "excluded_element"
+	rejectExcludedCombo   = "excluded_combo"
+)
+
+// rejection returns why Allows rejects c, or "" if it does not. Every
+// policy but none is a variant of the tier rule, so a combination the policy
+// rejects is reported as breaking it.
+func (cons *Constraints) rejection(c Combination) string {
+	if !cons.policy().Valid(c) {
+		return rejectPolicy
+	}
+	if cons == nil {
+		return ""
+	}
+	if cons.MaxTierJump > 0 {
+		if tierMap[c.Root]-tierMap[c.Left] > cons.MaxTierJump || tierMap[c.Root]-tierMap[c.Right] > cons.MaxTierJump {
+			return rejectTierJump
+		}
+	}
+	if !cons.AllowsElement(c.Root) || !cons.AllowsElement(c.Left) || !cons.AllowsElement(c.Right) {
+		return rejectExcludedElement
+	}
+	if cons.ExcludedCombos[comboKey(c.Left, c.Right)] {
+		return rejectExcludedCombo
+	}
+	return ""
+}
+
+// hasTreeLimits reports whether cons has constraints that Allows cannot
+// check on a single combination.
+func (cons *Constraints) hasTreeLimits() bool {
+	return cons != nil && (len(cons.Include) > 0 || cons.MaxDepth > 0 || cons.MaxSteps > 0)
+}
+
+// Satisfies checks the whole-tree constraints against a finished recipe.
+// Depth counts combination levels, so a basic element has depth 0; steps
+// counts combinations the way convertRecipeToPath lists them.
+func (cons *Constraints) Satisfies(n *Node) bool {
+	if n == nil {
+		return false
+	}
+	if cons == nil {
+		return true
+	}
+	if cons.MaxDepth > 0 && treeDepth(n)-1 > cons.MaxDepth {
+		return false
+	}
+	if cons.MaxSteps > 0 && countSteps(n) > cons.MaxSteps {
+		return false
+	}
+	for _, elem := range cons.Include {
+		if !treeContains(n, elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// enforceConstraints returns result if it already meets the whole-tree
+// constraints, and otherwise replaces it with FindConstrainedRecipe. Single
+// recipe searches only explore one tree, so this is how they honour include,
+// max_depth and max_steps.
+func enforceConstraints(target string, result *Node, cons *Constraints) (*Node, error) {
+	if !cons.hasTreeLimits() || cons.Satisfies(result) {
+		return result, nil
+	}
+	return FindConstrainedRecipe(target, cons)
+}
+
+func countSteps(n *Node) int {
+	if n == nil || (n.Left == nil && n.Right == nil) {
+		return 0
+	}
+	return 1 + countSteps(n.Left) + countSteps(n.Right)
+}
+
+func treeContains(n *Node, elem string) bool {
+	if n == nil {
+		return false
+	}
+	return n.Element == elem || treeContains(n.Left, elem) || treeContains(n.Right, elem)
+}
+
+// constrainedBudget caps the table entries constrainedRecipe may fill. Every
+// include element doubles the entries per state, so without a cap a handful
+// of them on a deep target reach gigabytes.
+const constrainedBudget = 1 << 21
+
+// constrainedStartDepth is the depth bound of FindConstrainedRecipe's first
+// pass when the policy does not bound recipe depth by tier.
+const constrainedStartDepth = 16
+
+// ErrSearchBudget is returned by FindConstrainedRecipe when the constraints
+// need more than constrainedBudget table entries. The search gives up
+// without knowing whether a recipe exists, so it is not a "no recipe"
+// answer.
+var ErrSearchBudget = errors.New("constrained search gave up: the constraints are too expensive for this element")
+
+type constrainedChoice struct {
+	steps     int
+	combo     Combination
+	leftMask  int
+	rightMask int
+}
+
+// FindConstrainedRecipe returns the recipe for target with the fewest steps
+// that satisfies every constraint in cons, or nil if there is none.
+//
+// It is a dynamic program over (element, remaining depth): for each state it
+// keeps, per subset of cons.Include covered by the subtree, the cheapest
+// subtree and the combination that produced it. The depth is bounded by the
+// target's tier under a tier-bounded policy, and otherwise by the number of
+// elements, since a shortest recipe never needs an element twice on one
+// branch; max_depth and max_steps can only lower the bound.
+//
+// Without a tier bound the full table is huge, so the program first runs
+// with a small depth bound and widens it as needed. A recipe is never deeper
+// than its number of steps, so once a pass finds one whose steps fit in its
+// bound, no deeper recipe can be shorter, and the answer is the one the full
+// table gives. A pass that would fill more than constrainedBudget table
+// entries gives up with ErrSearchBudget.
+func FindConstrainedRecipe(target string, cons *Constraints) (*Node, error) {
+	limit := len(tierMap)
+	if cons.policy().TierBounded() {
+		limit = tierMap[target]
+	}
+	if cons != nil && cons.MaxDepth > 0 {
+		limit = min(limit, cons.MaxDepth)
+	}
+	if cons != nil && cons.MaxSteps > 0 {
+		limit = min(limit, cons.MaxSteps)
+	}
+
+	depth := min(limit, constrainedStartDepth)
+	for {
+		node, steps, ok := constrainedRecipe(target, cons, depth)
+		switch {
+		case !ok:
+			return nil, ErrSearchBudget
+		case node != nil && (steps <= depth || depth == limit):
+			if cons != nil && cons.MaxSteps > 0 && steps > cons.MaxSteps {
+				return nil, nil
+			}
+			return node, nil
+		case node != nil:
+			depth = min(limit, steps)
+		case depth == limit:
+			return nil, nil
+		default:
+			depth = min(limit, 2*depth)
+		}
+	}
+}
+
+// constrainedRecipe is one pass of FindConstrainedRecipe with recipes
+// limited to depthLimit. It returns the recipe found and its steps, and ok
+// false if it ran out of budget.
+func constrainedRecipe(target string, cons *Constraints, depthLimit int) (*Node, int, bool) {
+	var include []string
+	if cons != nil {
+		include = cons.Include
+	}
+	index := make(map[string]int, len(include))
+	for i, elem := range include {
+		index[elem] = i
+	}
+	masks := 1 << len(include)
+
+	type state struct {
+		elem  string
+		depth int
+	}
+	memo := make(map[state][]constrainedChoice)
+	entries := 0
+
+	var solve func(elem string, depth int) []constrainedChoice
+	solve = func(elem string, depth int) []constrainedChoice {
+		key := state{elem, depth}
+		if best, ok := memo[key]; ok {
+			return best
+		}
+
+		best := make([]constrainedChoice, masks)
+		for m := range best {
+			best[m].steps = -1
+		}
+		if entries += masks; entries > constrainedBudget {
+			return best
+		}
+		memo[key] = best
+
+		self := 0
+		if i, ok := index[elem]; ok {
+			self = 1 << i
+		}
+		if !cons.AllowsElement(elem) {
+			return best
+		}
+		if isBasic(elem) {
+			best[self].steps = 0
+			return best
+		}
+		if depth == 0 {
+			return best
+		}
+
+		for _, c := range allowedCombos(elem, cons) {
+			left := solve(c.Left, depth-1)
+			right := solve(c.Right, depth-1)
+			for lm, l := range left {
+				if l.steps < 0 {
+					continue
+				}
+				for rm, r := range right {
+					if r.steps < 0 {
+						continue
+					}
+					m := lm | rm | self
+					steps := 1 + l.steps + r.steps
+					if best[m].steps < 0 || steps < best[m].steps {
+						best[m] = constrainedChoice{steps: steps, combo: c, leftMask: lm, rightMask: rm}
+					}
+				}
+			}
+		}
+		return best
+	}
+
+	full := masks - 1
+	root := solve(target, depthLimit)[full]
+	if entries > constrainedBudget {
+		return nil, 0, false
+	}
+	if root.steps < 0 {
+		return nil, 0, true
+	}
+
+	var build func(elem string, depth, mask int) *Node
+	build = func(elem string, depth, mask int) *Node {
+		choice := memo[state{elem, depth}][mask]
+		if choice.steps == 0 {
+			return &Node{Element: elem}
+		}
+		return &Node{
+			Element: elem,
+			Left:    build(choice.combo.Left, depth-1, choice.leftMask),
+			Right:   build(choice.combo.Right, depth-1, choice.rightMask),
+		}
+	}
+	return build(target, depthLimit, full), root.steps, true
+}
+
+// allowedCombos returns the combinations of elem that pass cons.Allows, in
+// the order cons.ordered gives them.
+func allowedCombos(elem string, cons *Constraints) []Combination {
+	valid := []Combination{}
+	for _, c := range cons.ordered(combinations[elem]) {
+		if cons.Allows(c) {
+			valid = append(valid, c)
+		}
+	}
+	return valid
+}
+
+// key spells out cons in a canonical form, so that equal constraints give
+// equal cache keys whatever order they were written in.
+func (cons *Constraints) key() string {
+	var excluded, combos, include []string
+	var maxDepth, maxSteps, maxTierJump int
+	var seed int64
+	if cons != nil {
+		for elem := range cons.ExcludedElements {
+			excluded = append(excluded, elem)
+		}
+		for combo := range cons.ExcludedCombos {
+			combos = append(combos, combo)
+		}
+		include = cons.Include
+		maxDepth, maxSteps, maxTierJump = cons.MaxDepth, cons.MaxSteps, cons.MaxTierJump
+		seed = cons.Seed
+	}
+	sort.Strings(excluded)
+	sort.Strings(combos)
+	return fmt.Sprintf("policy=%s;exclude=%s;exclude_combo=%s;include=%s;max_depth=%d;max_steps=%d;max_tier_jump=%d;seed=%d",
+		cons.policy().Name(), strings.Join(excluded, ","), strings.Join(combos, ","),
+		strings.Join(include, ","), maxDepth, maxSteps, maxTierJump, seed)
+}
+
+// ordered returns combos in the order searches try them. Without a seed that
+// is the order given. With one, they are sorted by an FNV-1a hash of the seed
+// and the combination, so a combination's rank depends only on the seed and
+// the combination itself, not on the rest of the dataset or on timing.
+func (cons *Constraints) ordered(combos []Combination) []Combination {
+	if cons == nil || cons.Seed == 0 || len(combos) < 2 {
+		return combos
+	}
+	ranks := make(map[Combination]uint64, len(combos))
+	for _, c := range combos {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d/%s=%s+%s", cons.Seed, c.Root, c.Left, c.Right)
+		ranks[c] = h.Sum64()
+	}
+	sorted := append([]Combination(nil), combos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return ranks[sorted[i]] < ranks[sorted[j]]
+	})
+	return sorted
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Cycle struct {
+	Elements    []string `json:"elements"`
+	BrokenEdges []string `json:"brokenEdges"`
+	LoopsUnder  []string `json:"loopsUnder"`
+}
+
+type CycleReport struct {
+	Components [][]string `json:"components"`
+	Cycles     []Cycle    `json:"cycles"`
+	Truncated  bool       `json:"truncated"`
+}
+
+// rawGraph returns the unfiltered element graph: an edge runs from each
+// ingredient to the element it helps make, whatever the tiers.
+func rawGraph() map[string][]string {
+	graph := make(map[string][]string)
+	seen := make(map[[2]string]bool)
+	for _, combos := range combinations {
+		for _, c := range combos {
+			for _, ingredient := range []string{c.Left, c.Right} {
+				if ingredient == "" {
+					continue
+				}
+				edge := [2]string{ingredient, c.Root}
+				if seen[edge] {
+					continue
+				}
+				seen[edge] = true
+				graph[ingredient] = append(graph[ingredient], c.Root)
+			}
+			if _, ok := graph[c.Root]; !ok {
+				graph[c.Root] = nil
+			}
+		}
+	}
+	for _, next := range graph {
+		sort.Strings(next)
+	}
+	return graph
+}
+
+// stronglyConnectedComponents runs Tarjan's algorithm over graph. Each
+// component is sorted, and components are returned largest first.
+func stronglyConnectedComponents(graph map[string][]string) [][]string {
+	nodes := make([]string, 0, len(graph))
+	for node := range graph {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var components [][]string
+	next := 0
+
+	var connect func(v string)
+	connect = func(v string) {
+		index[v] = next
+		low[v] = next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range graph[v] {
+			if _, visited := index[w]; !visited {
+				connect(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+
+		if low[v] == index[v] {
+			var component []string
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+
+	for _, node := range nodes {
+		if _, visited := index[node]; !visited {
+			connect(node)
+		}
+	}
+
+	sort.SliceStable(components, func(i, j int) bool {
+		if len(components[i]) != len(components[j]) {
+			return len(components[i]) > len(components[j])
+		}
+		return components[i][0] < components[j][0]
+	})
+	return components
+}
+
+// edgeAllowed reports whether some combination making product from
+// ingredient passes rule.
+func edgeAllowed(ingredient, product string, rule func(Combination) bool) bool {
+	for _, c := range combinations[product] {
+		if (c.Left == ingredient || c.Right == ingredient) && rule(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// shortestCycle finds the shortest cycle through start that stays inside
+// component, or nil if there is none.
+func shortestCycle(graph map[string][]string, component map[string]bool, start string) []string {
+	parent := map[string]string{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[current] {
+			if !component[next] {
+				continue
+			}
+			if next == start {
+				cycle := []string{current}
+				for cycle[0] != start {
+					cycle = append([]string{parent[cycle[0]]}, cycle...)
+				}
+				return cycle
+			}
+			if _, seen := parent[next]; seen {
+				continue
+			}
+			parent[next] = current
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// rotateCycle rotates cycle so that it starts at its smallest element, which
+// gives every cycle a single spelling.
+func rotateCycle(cycle []string) []string {
+	first := 0
+	for i, elem := range cycle {
+		if elem < cycle[first] {
+			first = i
+		}
+	}
+	return append(append([]string{}, cycle[first:]...), cycle[:first]...)
+}
+
+// AnalyzeCycles computes the strongly connected components of the raw
+// combination graph and, for every element in a cyclic component, the
+// shortest cycle through it. Each cycle lists the edges the strict tier rule
+// removes and the validity policies under which it survives, i.e. every
+// edge has a combination the policy accepts. Under "none" that is every
+// cycle, and only a visited set keeps a search from going round it forever.
+// At most limit cycles are returned when limit > 0.
+func AnalyzeCycles(limit int) CycleReport {
+	graph := rawGraph()
+	report := CycleReport{Components: [][]string{}, Cycles: []Cycle{}}
+	seen := make(map[string]bool)
+
+	for _, component := range stronglyConnectedComponents(graph) {
+		members := make(map[string]bool, len(component))
+		for _, elem := range component {
+			members[elem] = true
+		}
+		if len(component) == 1 && !edgeAllowed(component[0], component[0], func(Combination) bool { return true }) {
+			continue
+		}
+		report.Components = append(report.Components, component)
+
+		for _, start := range component {
+			cycle := shortestCycle(graph, members, start)
+			if cycle == nil {
+				continue
+			}
+			cycle = rotateCycle(cycle)
+			key := strings.Join(cycle, ">")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if limit > 0 && len(report.Cycles) >= limit {
+				report.Truncated = true
+				continue
+			}
+			report.Cycles = append(report.Cycles, describeCycle(cycle))
+		}
+	}
+	return report
+}
+
+func describeCycle(cycle []string) Cycle {
+	desc := Cycle{Elements: cycle, BrokenEdges: []string{}, LoopsUnder: []string{}}
+	for i, from := range cycle {
+		to := cycle[(i+1)%len(cycle)]
+		if !edgeAllowed(from, to, IsLowerTier) {
+			desc.BrokenEdges = append(desc.BrokenEdges, from+" -> "+to)
+		}
+	}
+
+	for _, policy := range validityPolicies {
+		survives := true
+		for i, from := range cycle {
+			if !edgeAllowed(from, cycle[(i+1)%len(cycle)], policy.Valid) {
+				survives = false
+				break
+			}
+		}
+		if survives {
+			desc.LoopsUnder = append(desc.LoopsUnder, policy.Name())
+		}
+	}
+	return desc
+}
+
+func handleCycles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnalyzeCycles(limit))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// diversityPool is how many candidates FindDiverseRecipes considers per
+// recipe it returns.
+const diversityPool = 8
+
+// FindDiverseRecipes returns up to maxCount recipes for target that differ
+// from each other as much as possible. It draws a pool of diversityPool
+// times as many candidates from FindMultipleRecipes' search, with each
+// top-level combination contributing at most maxCount of them, and then
+// picks from the pool greedily: it starts from the shallowest candidate and
+// keeps adding the one whose smallest recipeDistance to the picked recipes
+// is largest. Ties go to the candidate that comes first in the pool, so the
+// picks are as deterministic as the pool. The picks are returned in pool
+// order, i.e. shallowest first.
+func FindDiverseRecipes(target string, maxCount int, algorithm string, cons *Constraints) []*Node {
+	results, visited, _ := findDiverseRecipes(context.Background(), target, maxCount, algorithm, cons, nil)
+	atomic.StoreInt32(&MultiVisitedCount, int32(visited))
+	return results
+}
+
+// findDiverseRecipes is FindDiverseRecipes reporting the pool search's
+// progress to observe, with the pool search's visited count returned
+// instead of stored globally, and its error passed on.
+func findDiverseRecipes(ctx context.Context, target string, maxCount int, algorithm string, cons *Constraints, observe SearchObserver) ([]*Node, int, error) {
+	pool, visited, err := findMultipleRecipes(ctx, target, maxCount*diversityPool, maxCount, algorithm, cons, observe)
+	if err != nil || len(pool) <= maxCount {
+		return pool, visited, err
+	}
+
+	steps := make([]map[string]bool, len(pool))
+	for i, node := range pool {
+		steps[i] = recipeSteps(node)
+	}
+
+	picked := make([]bool, len(pool))
+	picked[0] = true
+	nearest := make([]float64, len(pool))
+	for i := range pool {
+		nearest[i] = recipeDistance(steps[0], steps[i])
+	}
+
+	for count := 1; count < maxCount; count++ {
+		best := -1
+		for i := range pool {
+			if !picked[i] && (best < 0 || nearest[i] > nearest[best]) {
+				best = i
+			}
+		}
+		picked[best] = true
+		for i := range pool {
+			nearest[i] = min(nearest[i], recipeDistance(steps[best], steps[i]))
+		}
+	}
+
+	results := make([]*Node, 0, maxCount)
+	for i, node := range pool {
+		if picked[i] {
+			results = append(results, node)
+		}
+	}
+	return results, visited, nil
+}
+
+// recipeSteps lists the combinations a recipe uses, ignoring ingredient
+// order.
+func recipeSteps(n *Node) map[string]bool {
+	steps := make(map[string]bool)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil || n.Left == nil || n.Right == nil {
+			return
+		}
+		steps[n.Element+"="+comboKey(n.Left.Element, n.Right.Element)] = true
+		walk(n.Left)
+		walk(n.Right)
+	}
+	walk(n)
+	return steps
+}
+
+// recipeDistance is the Jaccard distance between two recipes' combination
+// sets: 0 for recipes made the same way, 1 for recipes sharing no
+// combination. Recipes that differ in a single leaf subtree come out close,
+// and recipes with a different top-level combination usually far apart.
+func recipeDistance(a, b map[string]bool) float64 {
+	shared := 0
+	for step := range a {
+		if b[step] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return 1 - float64(shared)/float64(union)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Search event types. Every algorithm reports its progress with the same
+// events, so a client can animate any of them the same way.
+const (
+	// EventVisit: Element is expanded. Visited is the search's visited count
+	// so far and Frontier the number of elements waiting to be expanded.
+	EventVisit = "visit"
+	// EventPush: Element joins the frontier because Parent needs it.
+	EventPush = "push"
+	// EventRecipe: a recipe for Element made from Left and Right is built.
+	EventRecipe = "recipe"
+	// EventSkip: the combination Left + Right = Element is passed over;
+	// Reason says why.
+	EventSkip = "skip"
+	// EventMeet: the two halves of a bidirectional search meet at Element.
+	EventMeet = "meet"
+	// EventResult: the search is over; Result holds the final answer.
+	EventResult = "result"
+	// EventError: the search gave up without an answer; Error says why.
+	EventError = "error"
+)
+
+// SearchEvent is one step of a running search. Search is the element the
+// reporting search was started for, which differs from the requested target
+// for the ingredient searches of multiple-recipe mode. Direction is "forward"
+// or "backward" for the halves of a bidirectional search.
+//
+// Reason is one of the rejections of Constraints.Allows, such as tier_rule,
+// or craft_rank when DFS passes over a combination that does not lower the
+// craft rank.
+type SearchEvent struct {
+	Type      string          `json:"type"`
+	Search    string          `json:"search,omitempty"`
+	Element   string          `json:"element,omitempty"`
+	Parent    string          `json:"parent,omitempty"`
+	Left      string          `json:"left,omitempty"`
+	Right     string          `json:"right,omitempty"`
+	Direction string          `json:"direction,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Visited   int             `json:"visited,omitempty"`
+	Frontier  int             `json:"frontier,omitempty"`
+	Result    *SearchResponse `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// SearchObserver receives the events of a search as they happen. Searches
+// running in parallel call it concurrently. A nil observer drops them.
+type SearchObserver func(SearchEvent)
+
+func (observe SearchObserver) emit(event SearchEvent) {
+	if observe != nil {
+		observe(event)
+	}
+}
+
+// skipped reports the combinations of elem that cons rejects. It costs
+// nothing without an observer.
+func (observe SearchObserver) skipped(search, elem string, cons *Constraints) {
+	if observe == nil {
+		return
+	}
+	for _, c := range cons.ordered(combinations[elem]) {
+		if reason := cons.rejection(c); reason != "" {
+			observe(SearchEvent{Type: EventSkip, Search: search, Element: elem, Left: c.Left, Right: c.Right, Reason: reason})
+		}
+	}
+}
+
+// handleSearchStream runs a search like /search and streams its events as
+// Server-Sent Events, one per step, ending with a result event that carries
+// the /search response, or an error event if the search gave up. Once the
+// client goes away, sending stops and the search is cancelled through the
+// request's context.
+func handleSearchStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	req, err := parseSearchRequest(query)
+	if err != nil {
+		metrics.countSearch(query.Get("mode"), query.Get("recipe_mode"), outcomeBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ctx := r.Context()
+	var mu sync.Mutex
+	gone := false
+	send := func(event SearchEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if gone || ctx.Err() != nil {
+			gone = true
+			return
+		}
+		data, _ := json.Marshal(event)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			gone = true
+			return
+		}
+		flusher.Flush()
+	}
+
+	req.log = requestLogger(ctx)
+	req.ctx = ctx
+	response, err := runSearch(req, send)
+	if err != nil {
+		send(SearchEvent{Type: EventError, Search: req.element, Error: err.Error()})
+		return
+	}
+	send(SearchEvent{Type: EventResult, Search: req.element, Result: response})
+}
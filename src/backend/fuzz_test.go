@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fuzzDeadline and fuzzAllocLimit bound the work of one fuzz input. The
+// datasets involved are small, so reaching either means a search loops or
+// grows without bound.
+const (
+	fuzzDeadline   = 10 * time.Second
+	fuzzAllocLimit = 512 << 20
+)
+
+// bounded runs fn and fails t if it does not return within fuzzDeadline or
+// allocates more than fuzzAllocLimit bytes.
+func bounded(t *testing.T, what string, fn func()) {
+	t.Helper()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(fuzzDeadline):
+		t.Fatalf("%s did not finish within %v", what, fuzzDeadline)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > fuzzAllocLimit {
+		t.Fatalf("%s allocated %d MB", what, allocated>>20)
+	}
+}
+
+// fuzzSetup loads the real dataset once, so that useDataset has one to
+// restore, and disables and empties the recipe cache for the rest of the
+// fuzz run.
+func fuzzSetup(f *testing.F) {
+	loadDataset(f)
+	capacity := RecipeCacheBytes
+	RecipeCacheBytes = 0
+	recipeCache.Reset()
+	f.Cleanup(func() { RecipeCacheBytes = capacity })
+}
+
+func FuzzLoadCombinations(f *testing.F) {
+	fuzzSetup(f)
+	for _, seed := range []string{
+		`[{"root":"Mud","left":"Water","right":"Earth","tier":"1"},{"root":"Earth","left":"","right":"","tier":"0"}]`,
+		`[{"root":"Mud","left":"Mud","right":"Earth","tier":"1"}]`,
+		`[{"root":"Mud","left":"Water","right":"","tier":"1"}]`,
+		`[{"root":"Mud","left":"Water","right":"Earth","tier":1}]`,
+		`[{"root":"Mud","left":"Water","right":"Earth","tier":"-1"}]`,
+		`[{"root":"Mud","left":"Water","right":"Earth","tier":"99999999999"}]`,
+		`[{"root":"","left":"","right":"","tier":"0"}]`,
+		`[{"root":"Mud"},{"root":"Mud","tier":"2"}]`,
+		`[]`, `{}`, `null`, `[null]`, ``,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filename := filepath.Join(t.TempDir(), "combinations.json")
+		if err := os.WriteFile(filename, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		useDataset(t, nil)
+		previous := datasetHash
+		if err := LoadCombinations(filename); err != nil {
+			if datasetHash != previous {
+				t.Fatalf("failed load replaced the dataset: %v", err)
+			}
+			return
+		}
+
+		for root, combos := range combinations {
+			for _, c := range combos {
+				if c.Root != root || c.Tier != tierMap[root] || c.Tier < 0 {
+					t.Fatalf("combination %+v stored under %s with tier %d", c, root, tierMap[root])
+				}
+			}
+		}
+		checkFuzzGraph(t)
+	})
+}
+
+// fuzzGraph builds a dataset from data: the first byte picks the number of
+// elements, and every four bytes after it add a combination. Ingredients
+// range over the basic elements, the elements themselves and a few that are
+// never defined, so self-loops, cycles, missing ingredients and duplicate
+// combinations all come up.
+func fuzzGraph(data []byte) []Combination {
+	if len(data) == 0 {
+		return nil
+	}
+	n := 1 + int(data[0])%24
+	names := append([]string{}, basicElements...)
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("E%d", i))
+	}
+	names = append(names, "Missing0", "Missing1")
+
+	tiers := make([]int, n)
+	for i := range tiers {
+		tiers[i] = 1 + i%7
+	}
+	var combos []Combination
+	var roots []int
+	for rest := data[1:]; len(rest) >= 4; rest = rest[4:] {
+		root := int(rest[0]) % n
+		if rest[3]&0x80 != 0 {
+			tiers[root] = int(rest[3] & 0x0f)
+		}
+		roots = append(roots, root)
+		combos = append(combos, Combination{
+			Root:  names[len(basicElements)+root],
+			Left:  names[int(rest[1])%len(names)],
+			Right: names[int(rest[2])%len(names)],
+		})
+	}
+	for i, root := range roots {
+		combos[i].Tier = tiers[root]
+	}
+	return combos
+}
+
+// fanOut is fuzzGraph input giving E0 every pair of the other elements as a
+// combination.
+func fanOut() []byte {
+	data := []byte{23}
+	for l := 0; l < 28; l++ {
+		for r := l; r < 28; r++ {
+			data = append(data, 0, byte(l+5), byte(r+5), 0x8f)
+		}
+	}
+	return data
+}
+
+// checkFuzzGraph runs every algorithm on every element of the loaded
+// dataset under every policy and checks the answers against the reference
+// in properties_test.go.
+func checkFuzzGraph(t *testing.T) {
+	t.Helper()
+	for _, policy := range []string{"strict", "lte", "none"} {
+		p, _ := PolicyByName(policy)
+		cons := &Constraints{Policy: p}
+		craftable := craftableUnder(policy)
+		for _, element := range benchElements() {
+			want := isBasic(element) || craftable[element]
+			for _, mode := range singleRecipeModes {
+				var result *Node
+				var err error
+				bounded(t, fmt.Sprintf("%s %s under %s", mode, element, policy), func() {
+					result, _, err = searchSingleRecipe(context.Background(), element, mode, cons, nil)
+				})
+				if err != nil {
+					t.Fatalf("%s %s under %s: %v", mode, element, policy, err)
+				}
+				if (result != nil) != want {
+					t.Fatalf("%s %s under %s: found %v, craftable %v", mode, element, policy, result != nil, want)
+				}
+				if result != nil {
+					if problem := checkRecipe(result, element, policy); problem != "" {
+						t.Fatalf("%s %s under %s: %s", mode, element, policy, problem)
+					}
+				}
+
+				var results []*Node
+				bounded(t, fmt.Sprintf("multiple %s %s under %s", mode, element, policy), func() {
+					results, _, err = findMultipleRecipes(context.Background(), element, 3, 3, mode, cons, nil)
+				})
+				if err != nil {
+					t.Fatalf("multiple %s %s under %s: %v", mode, element, policy, err)
+				}
+				if (len(results) > 0) != want {
+					t.Fatalf("multiple %s %s under %s: found %v, craftable %v", mode, element, policy, len(results) > 0, want)
+				}
+				for _, result := range results {
+					if problem := checkRecipe(result, element, policy); problem != "" {
+						t.Fatalf("multiple %s %s under %s: %s", mode, element, policy, problem)
+					}
+				}
+				if problem := checkDistinct(results); problem != "" {
+					t.Fatalf("multiple %s %s under %s: %s", mode, element, policy, problem)
+				}
+			}
+
+			include := &Constraints{Policy: p, Include: []string{"E0"}, MaxSteps: 12}
+			bounded(t, fmt.Sprintf("constrained %s under %s", element, policy), func() {
+				result, err := FindConstrainedRecipe(element, include)
+				if err != nil && err != ErrSearchBudget {
+					t.Errorf("constrained %s under %s: %v", element, policy, err)
+				}
+				if result != nil {
+					if problem := checkRecipe(result, element, policy); problem != "" {
+						t.Errorf("constrained %s under %s: %s", element, policy, problem)
+					}
+					if !include.Satisfies(result) {
+						t.Errorf("constrained %s under %s: recipe breaks the constraints", element, policy)
+					}
+				}
+			})
+		}
+	}
+}
+
+func FuzzSearchGraph(f *testing.F) {
+	fuzzSetup(f)
+	f.Add([]byte{3, 0, 5, 6, 0x81, 1, 5, 7, 0x82, 2, 5, 6, 0x83})
+	f.Add([]byte{2, 0, 5, 0, 0x81, 0, 5, 6, 0x81, 1, 5, 1, 0x81})
+	f.Add([]byte{4, 0, 1, 2, 0, 1, 0, 3, 0, 2, 1, 3, 0, 3, 9, 10, 0x84})
+	f.Add([]byte{1, 0, 30, 31, 0x85, 0, 5, 31, 0x81})
+	f.Add(fanOut())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > 4096 {
+			return
+		}
+		useDataset(t, fuzzGraph(data))
+		checkFuzzGraph(t)
+	})
+}
+
+func FuzzSearchQuery(f *testing.F) {
+	fuzzSetup(f)
+	for _, seed := range []string{
+		"element=E3&mode=bfs&recipe_mode=single",
+		"element=E3&mode=dfs&recipe_mode=multiple&max_recipes=5",
+		"element=E3&mode=bidirectional&recipe_mode=multiple&max_recipes=999999999999&diverse=true&order=layered",
+		"element=E3&mode=bfs&recipe_mode=multiple&max_recipes=-3",
+		"element=Air&mode=bfs&recipe_mode=single&include=E1,E1,E2&max_depth=99999999&policy=none",
+		"element=E7&mode=dfs&recipe_mode=single&exclude=Fire&exclude_combo=Air+Water&max_steps=2&max_tier_jump=1",
+		"element=%00&mode=bfs&recipe_mode=single&debug=true",
+		"element=E1&mode=xyz&recipe_mode=single",
+		"element=&mode=bfs",
+		"element=E1&recipe_mode=multiple&mode=bfs&include=E1,E2,E3,E4,E5,E6,E7",
+		"element=E2;mode=bfs&&=&%zz",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, rawQuery string) {
+		useDataset(t, randomDataset(rand.New(rand.NewSource(1)), 30))
+
+		for _, endpoint := range []struct {
+			path    string
+			handler http.HandlerFunc
+		}{
+			{"/search", handleSearch},
+			{"/compare", handleCompare},
+		} {
+			r := httptest.NewRequest(http.MethodGet, endpoint.path, nil)
+			r.URL.RawQuery = rawQuery
+			w := httptest.NewRecorder()
+			bounded(t, endpoint.path+"?"+rawQuery, func() {
+				endpoint.handler(w, r)
+			})
+
+			switch w.Code {
+			case http.StatusOK:
+				if !json.Valid(w.Body.Bytes()) {
+					t.Fatalf("%s?%s: invalid JSON %q", endpoint.path, rawQuery, w.Body.String())
+				}
+			case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			default:
+				t.Fatalf("%s?%s: status %d", endpoint.path, rawQuery, w.Code)
+			}
+		}
+	})
+}
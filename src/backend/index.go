@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// indexVersion is bumped whenever the layout of the index file changes.
+const indexVersion = 1
+
+// searchVersion is bumped whenever a change to a single-recipe search alters
+// the recipe it returns or its visited count. Indexes store it, so that an
+// index built by older searches is rejected instead of serving their answers.
+const searchVersion = 1
+
+// indexModes are the single-recipe algorithms the index is built for.
+var indexModes = singleRecipeModes
+
+// indexTree is the on-disk form of a recipe tree.
+type indexTree struct {
+	Element string     `json:"e"`
+	Left    *indexTree `json:"l,omitempty"`
+	Right   *indexTree `json:"r,omitempty"`
+}
+
+// IndexedRecipe is the answer a single-recipe search gives without
+// constraints. Recipe is null for elements the algorithm cannot craft.
+type IndexedRecipe struct {
+	Recipe  *indexTree `json:"recipe"`
+	Visited int        `json:"visited"`
+}
+
+// IndexEntry holds the precomputed facts about one element under the strict
+// tier rule. MinDepth is -1 for elements with no legal recipe, and
+// RecipeCount is the number of distinct recipe trees.
+type IndexEntry struct {
+	MinDepth    int                      `json:"minDepth"`
+	RecipeCount *big.Int                 `json:"recipeCount"`
+	Best        map[string]IndexedRecipe `json:"best"`
+}
+
+// SolutionIndex is the file written by the index command. Dataset is the
+// datasetHash it was built from and Search the searchVersion that built it;
+// an index for another dataset or search version is ignored.
+type SolutionIndex struct {
+	Version  int                    `json:"version"`
+	Search   int                    `json:"search"`
+	Dataset  string                 `json:"dataset"`
+	Elements map[string]*IndexEntry `json:"elements"`
+}
+
+type indexedSolution struct {
+	node    *Node
+	visited int
+}
+
+// solutionIndex maps mode and element to the indexed answer, and
+// indexEntries maps each element to its entry. Both are nil when no index is
+// loaded.
+var (
+	solutionIndex map[string]indexedSolution
+	indexEntries  map[string]*IndexEntry
+)
+
+func toIndexTree(n *Node) *indexTree {
+	if n == nil {
+		return nil
+	}
+	return &indexTree{Element: n.Element, Left: toIndexTree(n.Left), Right: toIndexTree(n.Right)}
+}
+
+func (t *indexTree) node() *Node {
+	if t == nil {
+		return nil
+	}
+	return &Node{Element: t.Element, Left: t.Left.node(), Right: t.Right.node()}
+}
+
+// recipeCounts counts the distinct recipe trees of every element under the
+// strict tier rule. The rule makes the graph acyclic, so each count is the
+// sum over combinations of the product of the ingredients' counts.
+func recipeCounts() map[string]*big.Int {
+	counts := make(map[string]*big.Int)
+	var count func(elem string) *big.Int
+	count = func(elem string) *big.Int {
+		if c, ok := counts[elem]; ok {
+			return c
+		}
+		c := new(big.Int)
+		if isBasic(elem) {
+			c.SetInt64(1)
+		}
+		for _, combo := range allowedCombos(elem, nil) {
+			c.Add(c, new(big.Int).Mul(count(combo.Left), count(combo.Right)))
+		}
+		counts[elem] = c
+		return c
+	}
+	for elem := range tierMap {
+		count(elem)
+	}
+	return counts
+}
+
+// BuildIndex runs every single-recipe algorithm on every element without
+// constraints and records the answers along with recipe counts and minimal
+// depths.
+func BuildIndex() *SolutionIndex {
+	index := &SolutionIndex{
+		Version:  indexVersion,
+		Search:   searchVersion,
+		Dataset:  datasetHash,
+		Elements: make(map[string]*IndexEntry),
+	}
+
+	elements := make([]string, 0, len(tierMap))
+	for elem := range tierMap {
+		elements = append(elements, elem)
+	}
+	sort.Strings(elements)
+
+	ranks := craftRanks(nil)
+	counts := recipeCounts()
+	for _, elem := range elements {
+		entry := &IndexEntry{MinDepth: -1, RecipeCount: counts[elem], Best: make(map[string]IndexedRecipe)}
+		if rank, ok := ranks[elem]; ok {
+			entry.MinDepth = rank
+		}
+		for _, mode := range indexModes {
+			result, visited, _ := searchSingleRecipe(context.Background(), elem, mode, nil, nil)
+			entry.Best[mode] = IndexedRecipe{Recipe: toIndexTree(result), Visited: visited}
+		}
+		index.Elements[elem] = entry
+	}
+	return index
+}
+
+// WriteIndex saves index to filename.
+func WriteIndex(index *SolutionIndex, filename string) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0o644)
+}
+
+// LoadIndex reads an index written by the index command. A missing file
+// leaves the index empty, and an index of another version, search version or
+// dataset is rejected so that searches fall back to running live.
+func LoadIndex(filename string) error {
+	solutionIndex, indexEntries = nil, nil
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var index SolutionIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+	if index.Version != indexVersion {
+		return fmt.Errorf("index version %d, want %d", index.Version, indexVersion)
+	}
+	if index.Search != searchVersion {
+		return fmt.Errorf("index was built by search version %d, want %d", index.Search, searchVersion)
+	}
+	if index.Dataset != datasetHash {
+		return fmt.Errorf("index was built for another dataset")
+	}
+
+	solutionIndex = make(map[string]indexedSolution)
+	indexEntries = index.Elements
+	for elem, entry := range index.Elements {
+		for mode, best := range entry.Best {
+			solutionIndex[mode+"|"+elem] = indexedSolution{node: best.Recipe.node(), visited: best.Visited}
+		}
+	}
+	return nil
+}
+
+// lookupIndex returns the indexed answer of a single-recipe search. The index
+// only covers searches without constraints.
+func lookupIndex(element, mode string, cons *Constraints) (indexedSolution, bool) {
+	if solutionIndex == nil || cons.key() != (*Constraints)(nil).key() {
+		return indexedSolution{}, false
+	}
+	solution, ok := solutionIndex[mode+"|"+element]
+	return solution, ok
+}
+
+// ElementStats is what /elements/{name}/stats reports: the minimal depth and
+// recipe count of an element under the strict tier rule, as in IndexEntry.
+type ElementStats struct {
+	Element     string   `json:"element"`
+	Tier        int      `json:"tier"`
+	MinDepth    int      `json:"minDepth"`
+	RecipeCount *big.Int `json:"recipeCount"`
+	Indexed     bool     `json:"indexed"`
+}
+
+// elementStats reads the stats of element from the loaded index, or computes
+// them when there is none. Indexed says which.
+func elementStats(element string) ElementStats {
+	stats := ElementStats{Element: element, Tier: tierMap[element], MinDepth: -1}
+	if entry, ok := indexEntries[element]; ok && entry.RecipeCount != nil {
+		stats.MinDepth, stats.RecipeCount, stats.Indexed = entry.MinDepth, entry.RecipeCount, true
+		return stats
+	}
+	computed := computedStats()
+	if rank, ok := computed.ranks[element]; ok {
+		stats.MinDepth = rank
+	}
+	stats.RecipeCount = computed.counts[element]
+	return stats
+}
+
+// computedStats returns the craft ranks and recipe counts of every element,
+// from recipeCache when they were already computed for the loaded dataset.
+func computedStats() cachedRecipes {
+	key := recipeCacheKey("stats", "", "", 0, nil)
+	if cached, ok := recipeCache.Get(key); ok {
+		return cached
+	}
+	computed := cachedRecipes{ranks: craftRanks(nil), counts: recipeCounts()}
+	recipeCache.Put(key, computed)
+	return computed
+}
+
+func handleElementStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	element := r.PathValue("name")
+	if _, exists := tierMap[element]; !exists {
+		http.Error(w, "Element not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(elementStats(element))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logger is the server's log. setupLogging configures it from LOG_LEVEL
+// (debug, info, warn or error) and LOG_FORMAT (text or json).
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+func setupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: level}
+	if os.Getenv("LOG_FORMAT") == "json" {
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, options))
+	} else {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, options))
+	}
+	slog.SetDefault(logger)
+}
+
+type requestLoggerKey struct{}
+
+// requestLogger returns the logger of the request ctx belongs to, which
+// tags every record with the request ID.
+func requestLogger(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(requestLoggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+	return logger
+}
+
+// searchLog logs the progress of one search on the logger of its request,
+// tagged with the algorithm, recipe mode and target. It checks the level
+// before building any attribute, so searches pay nothing for it unless
+// debug logging is on.
+type searchLog struct {
+	ctx                           context.Context
+	log                           *slog.Logger
+	algorithm, recipeMode, target string
+}
+
+func newSearchLog(ctx context.Context, algorithm, recipeMode, target string) *searchLog {
+	return &searchLog{ctx: ctx, log: requestLogger(ctx), algorithm: algorithm, recipeMode: recipeMode, target: target}
+}
+
+func (l *searchLog) debug(msg string, attrs ...slog.Attr) {
+	if !l.log.Enabled(l.ctx, slog.LevelDebug) {
+		return
+	}
+	all := append([]slog.Attr{
+		slog.String("algorithm", l.algorithm),
+		slog.String("recipe_mode", l.recipeMode),
+		slog.String("target", l.target),
+	}, attrs...)
+	l.log.LogAttrs(l.ctx, slog.LevelDebug, msg, all...)
+}
+
+// newRequestID returns the ID the client sent in X-Request-ID, if it is
+// reasonable, or a fresh random one.
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 64 && !strings.ContainsAny(id, " \t\r\n") {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// logRequests gives every request an ID, echoed in the X-Request-ID header
+// and attached to its logger, and logs each request once it is answered.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID(r)
+		log := logger.With("request_id", id)
+		w.Header().Set("X-Request-ID", id)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestLoggerKey{}, log)))
+
+		log.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+			"status", recorder.status,
+			"duration", time.Since(start))
+	})
+}
+
+// statusRecorder remembers the status code of a response. It passes flushes
+// and hijacks through, so streams and WebSockets keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response cannot be hijacked")
+	}
+	rec.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// LogEntry is one log record returned by a debug=true request.
+type LogEntry struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"msg"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// maxCapturedLogs bounds the records a debug=true request returns. A search
+// logs an event per step, so a large one would otherwise hold its whole
+// trail in memory.
+const maxCapturedLogs = 10000
+
+// logCapture is a slog.Handler that keeps the first maxCapturedLogs records,
+// at any level, for the response of a debug=true request, and passes the
+// records next accepts on to it. Clones made by WithAttrs and WithGroup share
+// the captured records.
+type logCapture struct {
+	next  slog.Handler
+	attrs []slog.Attr
+	group string
+	buf   *captureBuffer
+}
+
+// captureBuffer holds the records of a logCapture and counts those dropped
+// once it is full.
+type captureBuffer struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	dropped int
+}
+
+func newLogCapture(next slog.Handler) *logCapture {
+	return &logCapture{next: next, buf: &captureBuffer{}}
+}
+
+func (c *logCapture) Enabled(context.Context, slog.Level) bool { return true }
+
+func (c *logCapture) Handle(ctx context.Context, record slog.Record) error {
+	entry := LogEntry{Time: record.Time, Level: record.Level.String(), Message: record.Message}
+	if len(c.attrs) > 0 || record.NumAttrs() > 0 {
+		entry.Attrs = make(map[string]any)
+		for _, attr := range c.attrs {
+			entry.Attrs[attr.Key] = attr.Value.Any()
+		}
+		record.Attrs(func(attr slog.Attr) bool {
+			entry.Attrs[c.group+attr.Key] = attr.Value.Resolve().Any()
+			return true
+		})
+	}
+	c.buf.mu.Lock()
+	if len(c.buf.entries) < maxCapturedLogs {
+		c.buf.entries = append(c.buf.entries, entry)
+	} else {
+		c.buf.dropped++
+	}
+	c.buf.mu.Unlock()
+
+	if c.next.Enabled(ctx, record.Level) {
+		return c.next.Handle(ctx, record)
+	}
+	return nil
+}
+
+func (c *logCapture) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *c
+	clone.next = c.next.WithAttrs(attrs)
+	clone.attrs = append([]slog.Attr(nil), c.attrs...)
+	for _, attr := range attrs {
+		clone.attrs = append(clone.attrs, slog.Attr{Key: c.group + attr.Key, Value: attr.Value.Resolve()})
+	}
+	return &clone
+}
+
+func (c *logCapture) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return c
+	}
+	clone := *c
+	clone.next = c.next.WithGroup(name)
+	clone.group = c.group + name + "."
+	return &clone
+}
+
+// Entries returns the records captured so far, and how many were dropped
+// because the capture was full.
+func (c *logCapture) Entries() ([]LogEntry, int) {
+	c.buf.mu.Lock()
+	defer c.buf.mu.Unlock()
+	return append([]LogEntry(nil), c.buf.entries...), c.buf.dropped
+}
+
+// logEvents is a SearchObserver that logs every search event at debug
+// level. Searches only report events to an observer, so the per-node trail
+// costs nothing unless someone asks for it.
+func logEvents(log *slog.Logger) SearchObserver {
+	return func(event SearchEvent) {
+		attrs := []slog.Attr{slog.String("type", event.Type), slog.String("search", event.Search)}
+		for _, field := range []struct{ key, value string }{
+			{"element", event.Element},
+			{"parent", event.Parent},
+			{"left", event.Left},
+			{"right", event.Right},
+			{"direction", event.Direction},
+			{"reason", event.Reason},
+		} {
+			if field.value != "" {
+				attrs = append(attrs, slog.String(field.key, field.value))
+			}
+		}
+		if event.Type == EventVisit {
+			attrs = append(attrs, slog.Int("visited", event.Visited), slog.Int("frontier", event.Frontier))
+		}
+		log.LogAttrs(context.Background(), slog.LevelDebug, "search event", attrs...)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bucket bounds of the search histograms.
+var (
+	durationBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+	visitedBuckets  = []float64{1, 10, 100, 1000, 10000, 100000, 1000000}
+)
+
+// histogram is a Prometheus histogram: counts[i] is the number of
+// observations no greater than bounds[i], and counts[len(bounds)] the total.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.counts[len(h.bounds)]++
+	h.sum += value
+}
+
+// searchLabels identifies one series of the search metrics.
+type searchLabels struct {
+	mode       string
+	recipeMode string
+}
+
+func (l searchLabels) String() string {
+	return fmt.Sprintf(`mode="%s",recipe_mode="%s"`, escapeLabel(l.mode), escapeLabel(l.recipeMode))
+}
+
+func (l searchLabels) less(other searchLabels) bool {
+	if l.mode != other.mode {
+		return l.mode < other.mode
+	}
+	return l.recipeMode < other.recipeMode
+}
+
+// newSearchLabels returns the labels of a search. Clients may send any mode
+// and recipe mode, so unknown ones share the label "other" to keep the
+// number of series bounded.
+func newSearchLabels(mode, recipeMode string) searchLabels {
+	switch mode {
+	case "bfs", "dfs", "bidirectional":
+	default:
+		mode = "other"
+	}
+	switch recipeMode {
+	case "single", "multiple":
+	default:
+		recipeMode = "other"
+	}
+	return searchLabels{mode: mode, recipeMode: recipeMode}
+}
+
+// Outcomes of a search or comparison request, the outcome label of the
+// request counters.
+const (
+	outcomeFound      = "found"
+	outcomeNotFound   = "not_found"
+	outcomeBadRequest = "bad_request"
+	outcomeGaveUp     = "gave_up"
+	outcomeCancelled  = "cancelled"
+)
+
+// requestLabels identifies one series of the request counters.
+type requestLabels struct {
+	searchLabels
+	outcome string
+}
+
+func (l requestLabels) String() string {
+	return fmt.Sprintf(`%s,outcome="%s"`, l.searchLabels, escapeLabel(l.outcome))
+}
+
+// searchMetrics collects the per-search series. Every search request is
+// counted by outcome; the duration and visited histograms only cover the
+// searches that were answered.
+type searchMetrics struct {
+	mu       sync.Mutex
+	requests map[requestLabels]uint64
+	compares map[string]uint64
+	duration map[searchLabels]*histogram
+	visited  map[searchLabels]*histogram
+
+	// multiTimeouts counts FindMultipleRecipes calls cut short by their
+	// deadline.
+	multiTimeouts atomic.Uint64
+}
+
+var metrics = &searchMetrics{
+	requests: make(map[requestLabels]uint64),
+	compares: make(map[string]uint64),
+	duration: make(map[searchLabels]*histogram),
+	visited:  make(map[searchLabels]*histogram),
+}
+
+// countSearch records a search request that was not answered, with why.
+func (m *searchMetrics) countSearch(mode, recipeMode, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{newSearchLabels(mode, recipeMode), outcome}]++
+}
+
+// observeSearch records one answered search.
+func (m *searchMetrics) observeSearch(mode, recipeMode string, elapsed time.Duration, visited int, found bool) {
+	labels := newSearchLabels(mode, recipeMode)
+	outcome := outcomeNotFound
+	if found {
+		outcome = outcomeFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{labels, outcome}]++
+	if m.duration[labels] == nil {
+		m.duration[labels] = newHistogram(durationBuckets)
+		m.visited[labels] = newHistogram(visitedBuckets)
+	}
+	m.duration[labels].observe(elapsed.Seconds())
+	m.visited[labels].observe(float64(visited))
+}
+
+// countCompare records one /compare request by outcome.
+func (m *searchMetrics) countCompare(outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.compares[outcome]++
+}
+
+// WriteMetrics writes every metric in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer) {
+	metrics.mu.Lock()
+	requests := make([]requestLabels, 0, len(metrics.requests))
+	for l := range metrics.requests {
+		requests = append(requests, l)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].searchLabels != requests[j].searchLabels {
+			return requests[i].searchLabels.less(requests[j].searchLabels)
+		}
+		return requests[i].outcome < requests[j].outcome
+	})
+	labels := make([]searchLabels, 0, len(metrics.duration))
+	for l := range metrics.duration {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].less(labels[j]) })
+	outcomes := make([]string, 0, len(metrics.compares))
+	for outcome := range metrics.compares {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+
+	writeHeader(w, "littlealchemy_search_requests_total", "counter", "Search requests, by algorithm, recipe mode and outcome.")
+	for _, l := range requests {
+		fmt.Fprintf(w, "littlealchemy_search_requests_total{%s} %d\n", l, metrics.requests[l])
+	}
+	writeHeader(w, "littlealchemy_search_duration_seconds", "histogram", "Time taken to answer a search.")
+	for _, l := range labels {
+		writeHistogram(w, "littlealchemy_search_duration_seconds", l.String(), metrics.duration[l])
+	}
+	writeHeader(w, "littlealchemy_search_visited_nodes", "histogram", "Nodes a search reported as visited.")
+	for _, l := range labels {
+		writeHistogram(w, "littlealchemy_search_visited_nodes", l.String(), metrics.visited[l])
+	}
+	writeHeader(w, "littlealchemy_compare_requests_total", "counter", "Comparison requests, by outcome.")
+	for _, outcome := range outcomes {
+		fmt.Fprintf(w, "littlealchemy_compare_requests_total{outcome=\"%s\"} %d\n", escapeLabel(outcome), metrics.compares[outcome])
+	}
+	metrics.mu.Unlock()
+
+	writeHeader(w, "littlealchemy_multiple_recipe_timeouts_total", "counter", "Multiple-recipe searches stopped by their deadline.")
+	fmt.Fprintf(w, "littlealchemy_multiple_recipe_timeouts_total %d\n", metrics.multiTimeouts.Load())
+
+	stats := recipeCache.Stats()
+	writeHeader(w, "littlealchemy_recipe_cache_hits_total", "counter", "Recipe cache lookups that found an entry.")
+	fmt.Fprintf(w, "littlealchemy_recipe_cache_hits_total %d\n", stats.Hits)
+	writeHeader(w, "littlealchemy_recipe_cache_misses_total", "counter", "Recipe cache lookups that found nothing.")
+	fmt.Fprintf(w, "littlealchemy_recipe_cache_misses_total %d\n", stats.Misses)
+	writeHeader(w, "littlealchemy_recipe_cache_evictions_total", "counter", "Entries evicted from the recipe cache.")
+	fmt.Fprintf(w, "littlealchemy_recipe_cache_evictions_total %d\n", stats.Evictions)
+	writeHeader(w, "littlealchemy_recipe_cache_hit_ratio", "gauge", "Share of recipe cache lookups that hit since startup.")
+	ratio := 0.0
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		ratio = float64(stats.Hits) / float64(lookups)
+	}
+	fmt.Fprintf(w, "littlealchemy_recipe_cache_hit_ratio %s\n", formatFloat(ratio))
+	writeHeader(w, "littlealchemy_recipe_cache_entries", "gauge", "Entries in the recipe cache.")
+	fmt.Fprintf(w, "littlealchemy_recipe_cache_entries %d\n", stats.Entries)
+	writeHeader(w, "littlealchemy_recipe_cache_bytes", "gauge", "Estimated size of the recipe cache.")
+	fmt.Fprintf(w, "littlealchemy_recipe_cache_bytes %d\n", stats.Bytes)
+
+	recipes := 0
+	for _, combos := range combinations {
+		recipes += len(combos)
+	}
+	writeHeader(w, "littlealchemy_dataset_elements", "gauge", "Elements in the loaded dataset.")
+	fmt.Fprintf(w, "littlealchemy_dataset_elements %d\n", len(tierMap))
+	writeHeader(w, "littlealchemy_dataset_recipes", "gauge", "Combinations in the loaded dataset.")
+	fmt.Fprintf(w, "littlealchemy_dataset_recipes %d\n", recipes)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeHeader(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+	writeHeader(w, "go_memstats_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+	fmt.Fprintf(w, "go_memstats_heap_alloc_bytes %d\n", mem.HeapAlloc)
+	writeHeader(w, "go_memstats_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.")
+	fmt.Fprintf(w, "go_memstats_heap_inuse_bytes %d\n", mem.HeapInuse)
+	writeHeader(w, "go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.")
+	fmt.Fprintf(w, "go_memstats_sys_bytes %d\n", mem.Sys)
+	writeHeader(w, "go_memstats_mallocs_total", "counter", "Heap objects allocated.")
+	fmt.Fprintf(w, "go_memstats_mallocs_total %d\n", mem.Mallocs)
+	writeHeader(w, "go_gc_cycles_total", "counter", "Completed GC cycles.")
+	fmt.Fprintf(w, "go_gc_cycles_total %d\n", mem.NumGC)
+	writeHeader(w, "go_gc_pause_seconds_total", "counter", "Total time the GC stopped the world.")
+	fmt.Fprintf(w, "go_gc_pause_seconds_total %s\n", formatFloat(float64(mem.PauseTotalNs)/1e9))
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), h.counts[i])
+	}
+	total := h.counts[len(h.bounds)]
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, total)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, total)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// escapeLabel escapes a label value for the text exposition format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
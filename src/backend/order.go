@@ -0,0 +1,189 @@
+package main
+
+import "sort"
+
+// Crafting order kinds accepted by the order query parameter.
+var orderKinds = map[string]bool{"dedupe": true, "layered": true, "churn": true}
+
+// OrderedStep is a Step with its place in a crafting order. Sources holds,
+// per ingredient, the number of the step that made it, or 0 for a basic
+// element. Steps with the same Layer do not depend on each other. Holding is
+// how many crafted elements the player still needs after this step,
+// including its result.
+type OrderedStep struct {
+	Number int `json:"number"`
+	Step
+	Sources []int `json:"sources"`
+	Layer   int   `json:"layer"`
+	Holding int   `json:"holding"`
+}
+
+// CraftingOrder is one linearization of a recipe in which every element is
+// crafted once.
+type CraftingOrder struct {
+	Kind          string        `json:"kind"`
+	Steps         []OrderedStep `json:"steps"`
+	Layers        int           `json:"layers"`
+	PeakInventory int           `json:"peakInventory"`
+}
+
+// recipeDAG merges the repeated subtrees of a recipe: each crafted element
+// keeps the first recipe the post-order walk meets for it.
+type recipeDAG struct {
+	made   map[string][2]string
+	order  []string
+	layer  map[string]int
+	uses   map[string]int
+	target string
+}
+
+func newRecipeDAG(root *Node) *recipeDAG {
+	dag := &recipeDAG{
+		made:   make(map[string][2]string),
+		layer:  make(map[string]int),
+		uses:   make(map[string]int),
+		target: root.Element,
+	}
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.Left == nil || n.Right == nil {
+			return
+		}
+		if _, done := dag.made[n.Element]; done {
+			return
+		}
+		walk(n.Left)
+		walk(n.Right)
+		dag.made[n.Element] = [2]string{n.Left.Element, n.Right.Element}
+		dag.order = append(dag.order, n.Element)
+		dag.layer[n.Element] = 1 + max(dag.layer[n.Left.Element], dag.layer[n.Right.Element])
+		for _, ingredient := range dag.ingredients(n.Element) {
+			dag.uses[ingredient]++
+		}
+	}
+	walk(root)
+	return dag
+}
+
+// ingredients returns the distinct ingredients of elem.
+func (dag *recipeDAG) ingredients(elem string) []string {
+	pair := dag.made[elem]
+	if pair[0] == pair[1] {
+		return pair[:1]
+	}
+	return pair[:]
+}
+
+// need is the Sethi-Ullman number of elem: how many crafted elements must be
+// held at once to craft it when the more demanding ingredient goes first.
+func (dag *recipeDAG) need(elem string, memo map[string]int) int {
+	if _, crafted := dag.made[elem]; !crafted {
+		return 0
+	}
+	if n, ok := memo[elem]; ok {
+		return n
+	}
+	first, second := dag.churnOrder(elem, memo)
+	n := max(1, dag.need(first, memo))
+	if _, crafted := dag.made[first]; crafted && first != second {
+		n = max(n, 1+dag.need(second, memo))
+	} else {
+		n = max(n, dag.need(second, memo))
+	}
+	memo[elem] = n
+	return n
+}
+
+// churnOrder returns the ingredients of elem with the one that needs more
+// held elements first. Ties keep the recipe's order.
+func (dag *recipeDAG) churnOrder(elem string, memo map[string]int) (string, string) {
+	pair := dag.made[elem]
+	if dag.need(pair[1], memo) > dag.need(pair[0], memo) {
+		return pair[1], pair[0]
+	}
+	return pair[0], pair[1]
+}
+
+// sequence returns the crafted elements in the order kind asks for:
+//   - dedupe: post-order, each element at its first use
+//   - layered: by layer, then in dedupe order
+//   - churn: post-order visiting the more demanding ingredient first, which
+//     keeps the number of elements held at once low
+func (dag *recipeDAG) sequence(kind string) []string {
+	switch kind {
+	case "layered":
+		order := append([]string(nil), dag.order...)
+		sort.SliceStable(order, func(i, j int) bool {
+			return dag.layer[order[i]] < dag.layer[order[j]]
+		})
+		return order
+	case "churn":
+		memo := make(map[string]int)
+		done := make(map[string]bool)
+		var order []string
+		var visit func(elem string)
+		visit = func(elem string) {
+			if _, crafted := dag.made[elem]; !crafted || done[elem] {
+				return
+			}
+			first, second := dag.churnOrder(elem, memo)
+			visit(first)
+			visit(second)
+			done[elem] = true
+			order = append(order, elem)
+		}
+		visit(dag.target)
+		return order
+	default:
+		return dag.order
+	}
+}
+
+// LinearizeRecipe lists the steps of recipe in the crafting order kind
+// ("dedupe", "layered" or "churn"), numbering them from 1 and linking every
+// ingredient to the step that made it.
+func LinearizeRecipe(recipe *Node, kind string) CraftingOrder {
+	order := CraftingOrder{Kind: kind, Steps: []OrderedStep{}}
+	if recipe == nil {
+		return order
+	}
+
+	dag := newRecipeDAG(recipe)
+	number := make(map[string]int)
+	remaining := make(map[string]int, len(dag.uses))
+	for elem, uses := range dag.uses {
+		remaining[elem] = uses
+	}
+
+	holding := 0
+	for _, elem := range dag.sequence(kind) {
+		pair := dag.made[elem]
+		step := OrderedStep{
+			Number:  len(order.Steps) + 1,
+			Step:    Step{Ingredients: []string{pair[0], pair[1]}, Result: elem},
+			Sources: []int{number[pair[0]], number[pair[1]]},
+			Layer:   dag.layer[elem],
+		}
+		step.Tiers.Left = tierMap[pair[0]]
+		step.Tiers.Right = tierMap[pair[1]]
+		step.Tiers.Result = tierMap[elem]
+
+		holding++
+		for _, ingredient := range dag.ingredients(elem) {
+			if _, crafted := dag.made[ingredient]; !crafted {
+				continue
+			}
+			remaining[ingredient]--
+			if remaining[ingredient] == 0 {
+				holding--
+			}
+		}
+		step.Holding = holding
+
+		number[elem] = step.Number
+		order.Steps = append(order.Steps, step)
+		order.Layers = max(order.Layers, step.Layer)
+		order.PeakInventory = max(order.PeakInventory, holding)
+	}
+	return order
+}
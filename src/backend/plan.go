@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// maxPlanPermutations bounds how many target orderings PlanTargets tries.
+// 720 is every ordering of six targets.
+const maxPlanPermutations = 720
+
+// maxPlanTargets caps the targets of one plan. Each ordering PlanTargets
+// tries plans every target, so the work grows with their number.
+const maxPlanTargets = 12
+
+type Plan struct {
+	Targets       []string `json:"targets"`
+	Missing       []string `json:"missing"`
+	Steps         []Step   `json:"steps"`
+	CombinedSteps int      `json:"combinedSteps"`
+	SeparateSteps int      `json:"separateSteps"`
+	Saved         int      `json:"saved"`
+}
+
+// planTarget picks a recipe for target that needs as few combinations as
+// possible on top of made, records the combination used for each new
+// element in recipeOf and marks it as made. Elements already in made cost
+// nothing. It returns false if target cannot be crafted.
+//
+// The cost of a recipe is the number of combinations in its tree, so a
+// sub-recipe used on two branches counts twice; it bounds the number of new
+// elements the recipe adds from above. Costs are settled bottom-up in
+// increasing order, as in Knuth's generalisation of Dijkstra's algorithm to
+// AND-OR graphs: the cheapest unsettled element is final, and a combination
+// is priced once both its ingredients are final. Unlike a top-down search,
+// this stays exact when the policy lets recipes form cycles.
+func planTarget(target string, made map[string]bool, recipeOf map[string]Combination, cons *Constraints) bool {
+	cost := make(map[string]int)
+	choice := make(map[string]Combination)
+	settled := make(map[string]bool)
+
+	// buckets[c] holds the elements priced at c, in the order they were
+	// priced. A combination costs more than its ingredients, so buckets only
+	// grow above the one being settled.
+	var buckets [][]string
+	price := func(elem string, c int) {
+		for len(buckets) <= c {
+			buckets = append(buckets, nil)
+		}
+		cost[elem] = c
+		buckets[c] = append(buckets[c], elem)
+	}
+
+	free := make([]string, 0, len(made))
+	for elem := range made {
+		free = append(free, elem)
+	}
+	sort.Strings(free)
+	for _, elem := range append(getSortedBasicElements(), free...) {
+		if cons.AllowsElement(elem) {
+			price(elem, 0)
+		}
+	}
+
+settle:
+	for c := 0; c < len(buckets); c++ {
+		for _, elem := range buckets[c] {
+			if settled[elem] || cost[elem] != c {
+				continue
+			}
+			settled[elem] = true
+			if elem == target {
+				break settle
+			}
+			for _, combo := range ingredientMap[elem] {
+				if settled[combo.Root] || !settled[combo.Left] || !settled[combo.Right] || !cons.Allows(combo) {
+					continue
+				}
+				total := 1 + cost[combo.Left] + cost[combo.Right]
+				if combo.Left == combo.Right {
+					total = 1 + cost[combo.Left]
+				}
+				if old, priced := cost[combo.Root]; !priced || total < old {
+					choice[combo.Root] = combo
+					price(combo.Root, total)
+				}
+			}
+		}
+	}
+
+	if !settled[target] {
+		return false
+	}
+
+	var mark func(elem string)
+	mark = func(elem string) {
+		if made[elem] || isBasic(elem) {
+			return
+		}
+		c := choice[elem]
+		made[elem] = true
+		recipeOf[elem] = c
+		mark(c.Left)
+		mark(c.Right)
+	}
+	mark(target)
+	return true
+}
+
+// planInOrder plans targets one after another so that later targets reuse
+// the intermediates of earlier ones.
+func planInOrder(targets []string, cons *Constraints) (map[string]Combination, []string) {
+	made := make(map[string]bool)
+	recipeOf := make(map[string]Combination)
+	missing := []string{}
+	for _, target := range targets {
+		if !planTarget(target, made, recipeOf, cons) {
+			missing = append(missing, target)
+		}
+	}
+	return recipeOf, missing
+}
+
+// PlanTargets builds one crafting plan for several targets that crafts every
+// shared intermediate only once. Choosing the smallest shared plan is a
+// Steiner-tree problem on the AND-OR graph, so this is a greedy heuristic:
+// each target takes the recipe that adds the fewest new elements given what
+// earlier targets already made, and every target ordering is tried (up to
+// maxPlanPermutations) keeping the plan with the fewest steps.
+//
+// Targets listed more than once are planned once. Only the per-combination
+// constraints in cons shape the shared plan. SeparateSteps is the total
+// number of steps that separate single-recipe searches with mode would
+// return; the plan fails if one of them gives up.
+func PlanTargets(targets []string, mode string, cons *Constraints) (Plan, error) {
+	listed := make(map[string]bool)
+	unique := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if !listed[target] {
+			listed[target] = true
+			unique = append(unique, target)
+		}
+	}
+	targets = unique
+	plan := Plan{Targets: targets}
+
+	for _, target := range targets {
+		result, _, err := findSingleRecipe(context.Background(), target, mode, cons)
+		if err != nil {
+			return Plan{}, err
+		}
+		if result != nil {
+			plan.SeparateSteps += countSteps(result)
+		}
+	}
+
+	order := append([]string(nil), targets...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return tierMap[order[i]] > tierMap[order[j]]
+	})
+
+	var bestRecipes map[string]Combination
+	var bestMissing []string
+	tried := 0
+	permute(order, 0, func(perm []string) bool {
+		recipeOf, missing := planInOrder(perm, cons)
+		if bestRecipes == nil || len(missing) < len(bestMissing) ||
+			(len(missing) == len(bestMissing) && len(recipeOf) < len(bestRecipes)) {
+			bestRecipes, bestMissing = recipeOf, missing
+		}
+		tried++
+		return tried < maxPlanPermutations
+	})
+
+	plan.Missing = bestMissing
+	sort.Strings(plan.Missing)
+
+	done := make(map[string]bool)
+	var emit func(elem string)
+	emit = func(elem string) {
+		c, ok := bestRecipes[elem]
+		if !ok || done[elem] {
+			return
+		}
+		done[elem] = true
+		emit(c.Left)
+		emit(c.Right)
+
+		step := Step{Ingredients: []string{c.Left, c.Right}, Result: elem}
+		step.Tiers.Left = tierMap[c.Left]
+		step.Tiers.Right = tierMap[c.Right]
+		step.Tiers.Result = tierMap[elem]
+		plan.Steps = append(plan.Steps, step)
+	}
+	plan.Steps = []Step{}
+	for _, target := range targets {
+		emit(target)
+	}
+
+	plan.CombinedSteps = len(plan.Steps)
+	plan.Saved = plan.SeparateSteps - plan.CombinedSteps
+	return plan, nil
+}
+
+// permute calls visit with every ordering of items[k:], stopping as soon as
+// visit returns false.
+func permute(items []string, k int, visit func([]string) bool) bool {
+	if k == len(items) {
+		return visit(items)
+	}
+	for i := k; i < len(items); i++ {
+		items[k], items[i] = items[i], items[k]
+		ok := permute(items, k+1, visit)
+		items[k], items[i] = items[i], items[k]
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func handlePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	targets := splitList(r.URL.Query().Get("targets"))
+	if len(targets) == 0 {
+		http.Error(w, "Targets parameter is required", http.StatusBadRequest)
+		return
+	}
+	if len(targets) > maxPlanTargets {
+		http.Error(w, fmt.Sprintf("At most %d targets", maxPlanTargets), http.StatusBadRequest)
+		return
+	}
+	for _, target := range targets {
+		if _, exists := tierMap[target]; !exists {
+			http.Error(w, "Element not found: "+target, http.StatusNotFound)
+			return
+		}
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "bfs"
+	}
+	switch mode {
+	case "bfs", "dfs", "bidirectional":
+	default:
+		http.Error(w, "Invalid mode", http.StatusBadRequest)
+		return
+	}
+
+	cons, err := ParseConstraints(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plan, err := PlanTargets(targets, mode, cons)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// ValidityPolicy decides which combinations a recipe may use.
+type ValidityPolicy interface {
+	Name() string
+	Valid(c Combination) bool
+	// TierBounded reports whether every valid combination strictly raises
+	// the tier, which bounds recipe depth by the target's tier and rules
+	// out cycles.
+	TierBounded() bool
+}
+
+type strictTierPolicy struct{}
+
+func (strictTierPolicy) Name() string             { return "strict" }
+func (strictTierPolicy) Valid(c Combination) bool { return IsLowerTier(c) }
+func (strictTierPolicy) TierBounded() bool        { return true }
+
+type lowerOrEqualTierPolicy struct{}
+
+func (lowerOrEqualTierPolicy) Name() string             { return "lte" }
+func (lowerOrEqualTierPolicy) Valid(c Combination) bool { return isLowerOrEqualTier(c) }
+func (lowerOrEqualTierPolicy) TierBounded() bool        { return false }
+
+// noTierPolicy accepts every combination in the dataset. The graph then has
+// cycles, so searches rely on their visited sets and bottom-up construction
+// to terminate.
+type noTierPolicy struct{}
+
+func (noTierPolicy) Name() string             { return "none" }
+func (noTierPolicy) Valid(c Combination) bool { return c.Left != "" && c.Right != "" }
+func (noTierPolicy) TierBounded() bool        { return false }
+
+// whitelistPolicy is the strict tier rule plus the combinations listed in
+// the whitelist file, for in-game recipes whose tiers are mislabelled.
+type whitelistPolicy struct{}
+
+func (whitelistPolicy) Name() string { return "whitelist" }
+func (whitelistPolicy) Valid(c Combination) bool {
+	return IsLowerTier(c) || whitelist[c.Root+"="+comboKey(c.Left, c.Right)]
+}
+func (whitelistPolicy) TierBounded() bool { return false }
+
+// validityPolicies lists the selectable policies, strictest first.
+var validityPolicies = []ValidityPolicy{
+	strictTierPolicy{},
+	lowerOrEqualTierPolicy{},
+	noTierPolicy{},
+	whitelistPolicy{},
+}
+
+var whitelist map[string]bool
+
+// LoadWhitelist reads the combinations the whitelist policy accepts
+// regardless of tier. The file uses the same format as combinations.json
+// (tier may be omitted). A missing file leaves the whitelist empty.
+func LoadWhitelist(filename string) error {
+	whitelist = make(map[string]bool)
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var raw []Combination
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, c := range raw {
+		whitelist[c.Root+"="+comboKey(c.Left, c.Right)] = true
+	}
+	return nil
+}
+
+func isLowerOrEqualTier(c Combination) bool {
+	return tierMap[c.Left] <= tierMap[c.Root] && tierMap[c.Right] <= tierMap[c.Root]
+}
+
+// PolicyByName looks up a policy by the name used in the policy query
+// parameter. An empty name selects the strict tier rule.
+func PolicyByName(name string) (ValidityPolicy, error) {
+	if name == "" {
+		return strictTierPolicy{}, nil
+	}
+	for _, policy := range validityPolicies {
+		if policy.Name() == name {
+			return policy, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid policy: %s", name)
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// validUnder is the reference statement of each validity policy, written
+// out independently of policy.go.
+func validUnder(policy string, c Combination) bool {
+	left, right, root := tierMap[c.Left], tierMap[c.Right], tierMap[c.Root]
+	switch policy {
+	case "strict":
+		return left < root && right < root
+	case "lte":
+		return left <= root && right <= root
+	case "whitelist":
+		return (left < root && right < root) || whitelist[c.Root+"="+comboKey(c.Left, c.Right)]
+	default:
+		return true
+	}
+}
+
+// checkRecipe reports the first way n fails to be a well-formed recipe for
+// target under policy, or "" if it is one: every internal node has two
+// children and is made by a combination in the dataset that the policy
+// accepts, and every leaf is basic.
+func checkRecipe(n *Node, target, policy string) string {
+	if n == nil {
+		return "nil recipe"
+	}
+	if n.Element != target {
+		return fmt.Sprintf("root is %s, want %s", n.Element, target)
+	}
+	var check func(n *Node) string
+	check = func(n *Node) string {
+		if n.Left == nil && n.Right == nil {
+			if !isBasic(n.Element) {
+				return fmt.Sprintf("leaf %s is not basic", n.Element)
+			}
+			return ""
+		}
+		if n.Left == nil || n.Right == nil {
+			return fmt.Sprintf("%s has a single child", n.Element)
+		}
+		matched, valid := false, false
+		for _, c := range combinations[n.Element] {
+			if comboKey(c.Left, c.Right) == comboKey(n.Left.Element, n.Right.Element) {
+				matched = true
+				valid = valid || validUnder(policy, c)
+			}
+		}
+		switch {
+		case !matched:
+			return fmt.Sprintf("%s = %s + %s is not in the dataset", n.Element, n.Left.Element, n.Right.Element)
+		case !valid:
+			return fmt.Sprintf("%s = %s + %s breaks the %s policy", n.Element, n.Left.Element, n.Right.Element, policy)
+		}
+		if problem := check(n.Left); problem != "" {
+			return problem
+		}
+		return check(n.Right)
+	}
+	if problem := check(n); problem != "" {
+		return problem
+	}
+	if policy == "strict" && treeDepth(n)-1 > tierMap[target] {
+		return fmt.Sprintf("depth %d exceeds tier %d", treeDepth(n)-1, tierMap[target])
+	}
+	return checkPath(n)
+}
+
+// checkPath reports how convertRecipeToPath(n) disagrees with n: it must
+// list each combination of the tree once, with the tiers of the dataset,
+// only use ingredients that are basic or made by an earlier step, and end
+// with the root.
+func checkPath(n *Node) string {
+	path := convertRecipeToPath(n)
+	want := make(map[string]int)
+	var collect func(n *Node)
+	collect = func(n *Node) {
+		if n.Left == nil {
+			return
+		}
+		want[n.Element+"="+n.Left.Element+"+"+n.Right.Element]++
+		collect(n.Left)
+		collect(n.Right)
+	}
+	collect(n)
+
+	if len(path) != countSteps(n) {
+		return fmt.Sprintf("path has %d steps, tree has %d combinations", len(path), countSteps(n))
+	}
+	if len(path) > 0 && path[len(path)-1].Result != n.Element {
+		return fmt.Sprintf("path ends with %s, not the root %s", path[len(path)-1].Result, n.Element)
+	}
+	made := make(map[string]bool)
+	for i, step := range path {
+		if len(step.Ingredients) != 2 {
+			return fmt.Sprintf("step %d has %d ingredients", i, len(step.Ingredients))
+		}
+		for _, ingredient := range step.Ingredients {
+			if !isBasic(ingredient) && !made[ingredient] {
+				return fmt.Sprintf("step %d uses %s before it is made", i, ingredient)
+			}
+		}
+		if step.Tiers.Left != tierMap[step.Ingredients[0]] || step.Tiers.Right != tierMap[step.Ingredients[1]] ||
+			step.Tiers.Result != tierMap[step.Result] {
+			return fmt.Sprintf("step %d has the wrong tiers", i)
+		}
+		key := step.Result + "=" + step.Ingredients[0] + "+" + step.Ingredients[1]
+		if want[key] == 0 {
+			return fmt.Sprintf("step %d (%s) is not in the tree", i, key)
+		}
+		want[key]--
+		made[step.Result] = true
+	}
+	return ""
+}
+
+// sameRecipe is the reference notion of duplicate recipes: equal trees,
+// ignoring the order of each combination's ingredients.
+func sameRecipe(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Element != b.Element {
+		return false
+	}
+	return (sameRecipe(a.Left, b.Left) && sameRecipe(a.Right, b.Right)) ||
+		(sameRecipe(a.Left, b.Right) && sameRecipe(a.Right, b.Left))
+}
+
+// checkDistinct reports two recipes in nodes that are the same recipe or
+// share a serializeTree signature.
+func checkDistinct(nodes []*Node) string {
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			if sameRecipe(nodes[i], nodes[j]) {
+				return fmt.Sprintf("recipes %d and %d are the same", i, j)
+			}
+			if serializeTree(nodes[i]) == serializeTree(nodes[j]) {
+				return fmt.Sprintf("recipes %d and %d serialize alike", i, j)
+			}
+		}
+	}
+	return ""
+}
+
+// craftableUnder computes which elements have a recipe under policy by
+// iterating to a fixed point over the dataset.
+func craftableUnder(policy string) map[string]bool {
+	craftable := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for root, combos := range combinations {
+			if craftable[root] || isBasic(root) {
+				continue
+			}
+			for _, c := range combos {
+				if validUnder(policy, c) && (isBasic(c.Left) || craftable[c.Left]) && (isBasic(c.Right) || craftable[c.Right]) {
+					craftable[root] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return craftable
+}
+
+// checkAlgorithms searches every element of the loaded dataset under policy
+// with every algorithm, once for a single recipe and once for multiple
+// recipes. Every recipe must be well-formed, and each algorithm must find
+// recipes exactly when the element is craftable, in both modes alike.
+func checkAlgorithms(t *testing.T, policy string) {
+	t.Helper()
+	p, err := PolicyByName(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cons := &Constraints{Policy: p}
+	craftable := craftableUnder(policy)
+
+	for _, element := range benchElements() {
+		want := isBasic(element) || craftable[element]
+		for _, mode := range singleRecipeModes {
+			result, _, err := searchSingleRecipe(context.Background(), element, mode, cons, nil)
+			if err != nil {
+				t.Errorf("%s %s under %s: %v", mode, element, policy, err)
+				continue
+			}
+			if (result != nil) != want {
+				t.Errorf("%s %s under %s: found %v, craftable %v", mode, element, policy, result != nil, want)
+			}
+			if result != nil {
+				if problem := checkRecipe(result, element, policy); problem != "" {
+					t.Errorf("%s %s under %s: %s", mode, element, policy, problem)
+				}
+			}
+
+			results, _, err := findMultipleRecipes(context.Background(), element, 5, 5, mode, cons, nil)
+			if err != nil {
+				t.Errorf("multiple %s %s under %s: %v", mode, element, policy, err)
+				continue
+			}
+			if len(results) > 5 {
+				t.Errorf("multiple %s %s under %s: %d recipes, asked for 5", mode, element, policy, len(results))
+			}
+			if (len(results) > 0) != (result != nil) {
+				t.Errorf("multiple %s %s under %s: found %v, single mode found %v", mode, element, policy, len(results) > 0, result != nil)
+			}
+			for i, result := range results {
+				if problem := checkRecipe(result, element, policy); problem != "" {
+					t.Errorf("multiple %s %s under %s, recipe %d: %s", mode, element, policy, i, problem)
+				}
+			}
+			if problem := checkDistinct(results); problem != "" {
+				t.Errorf("multiple %s %s under %s: %s", mode, element, policy, problem)
+			}
+		}
+	}
+}
+
+func TestRecipesWellFormed(t *testing.T) {
+	loadDataset(t)
+	defer func(capacity int64) { RecipeCacheBytes = capacity }(RecipeCacheBytes)
+	RecipeCacheBytes = 0
+	recipeCache.Reset()
+
+	// serializeTree separates ingredients with commas, so names must not
+	// contain any for its signatures to tell recipes apart.
+	for _, element := range benchElements() {
+		if strings.Contains(element, ",") {
+			t.Errorf("element name %q contains a comma", element)
+		}
+	}
+
+	checkAlgorithms(t, "strict")
+	if testing.Short() {
+		return
+	}
+	// The other policies only read the dataset, so they are checked side
+	// by side; the group returns once all of them are done.
+	t.Run("policies", func(t *testing.T) {
+		for _, policy := range []string{"lte", "none", "whitelist"} {
+			t.Run(policy, func(t *testing.T) {
+				t.Parallel()
+				checkAlgorithms(t, policy)
+			})
+		}
+	})
+}
+
+// TestPlanTargets checks that a shared plan crafts every craftable target
+// under each policy, only with combinations the policy accepts, each
+// element once and never before its ingredients.
+func TestPlanTargets(t *testing.T) {
+	loadDataset(t)
+	for _, policy := range []string{"strict", "lte", "none"} {
+		p, _ := PolicyByName(policy)
+		cons := &Constraints{Policy: p}
+		craftable := craftableUnder(policy)
+		for _, targets := range [][]string{
+			{"Human", "Golem", "Human"},
+			{"Dragon", "Phoenix", "Unicorn"},
+			{"Beach", "Sand", "Glass", "Obsidian"},
+			{"Life", "Time", "Air"},
+		} {
+			plan, err := PlanTargets(targets, "bfs", cons)
+			if err != nil {
+				t.Fatalf("%v under %s: %v", targets, policy, err)
+			}
+
+			listed := make(map[string]bool)
+			var missing []string
+			for _, target := range plan.Targets {
+				if listed[target] {
+					t.Errorf("%v under %s: %s planned twice", targets, policy, target)
+				}
+				listed[target] = true
+				if !isBasic(target) && !craftable[target] {
+					missing = append(missing, target)
+				}
+			}
+			if fmt.Sprint(plan.Missing) != fmt.Sprint(sortedCopy(missing)) {
+				t.Errorf("%v under %s: missing %v, want %v", targets, policy, plan.Missing, missing)
+			}
+
+			made := make(map[string]bool)
+			for i, step := range plan.Steps {
+				c := Combination{Root: step.Result, Left: step.Ingredients[0], Right: step.Ingredients[1]}
+				if !hasCombination(c) || !validUnder(policy, c) {
+					t.Errorf("%v under %s: step %d (%s = %s + %s) is not allowed", targets, policy, i, c.Root, c.Left, c.Right)
+				}
+				for _, ingredient := range step.Ingredients {
+					if !isBasic(ingredient) && !made[ingredient] {
+						t.Errorf("%v under %s: step %d uses %s before it is made", targets, policy, i, ingredient)
+					}
+				}
+				if made[step.Result] {
+					t.Errorf("%v under %s: %s is made twice", targets, policy, step.Result)
+				}
+				made[step.Result] = true
+			}
+			for target := range listed {
+				if !isBasic(target) && craftable[target] && !made[target] {
+					t.Errorf("%v under %s: %s is not made", targets, policy, target)
+				}
+			}
+			if plan.CombinedSteps != len(plan.Steps) || plan.Saved != plan.SeparateSteps-plan.CombinedSteps {
+				t.Errorf("%v under %s: inconsistent counts %+v", targets, policy, plan)
+			}
+		}
+	}
+}
+
+func hasCombination(c Combination) bool {
+	for _, d := range combinations[c.Root] {
+		if comboKey(d.Left, d.Right) == comboKey(c.Left, c.Right) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedCopy(items []string) []string {
+	sorted := append([]string{}, items...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// useDataset loads combos as the dataset for the rest of the test and
+// restores the previous one afterwards.
+func useDataset(t *testing.T, combos []Combination) {
+	t.Helper()
+	saved := []any{combinations, tierMap, reverseMap, ingredientMap, datasetHash}
+	t.Cleanup(func() {
+		combinations = saved[0].(map[string][]Combination)
+		tierMap = saved[1].(map[string]int)
+		reverseMap = saved[2].(map[string][]string)
+		ingredientMap = saved[3].(map[string][]Combination)
+		datasetHash = saved[4].(string)
+	})
+
+	data, err := json.Marshal(combos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "combinations.json")
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadCombinations(filename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var basicElements = []string{"Air", "Earth", "Fire", "Water", "Time"}
+
+// randomDataset builds a dataset of n elements with random tiers. Most
+// combinations use lower-tier ingredients, but some use equal or higher
+// tiers, the element itself, or ingredients the dataset never defines, and
+// a few elements get many combinations.
+func randomDataset(rng *rand.Rand, n int) []Combination {
+	names := make([]string, n)
+	tiers := make([]int, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("E%d", i)
+		tiers[i] = 1 + rng.Intn(6)
+	}
+	ingredient := func(i int) string {
+		switch r := rng.Intn(20); {
+		case r < 6:
+			return basicElements[rng.Intn(len(basicElements))]
+		case r == 6:
+			return names[i]
+		case r == 7:
+			return fmt.Sprintf("Missing%d", rng.Intn(3))
+		default:
+			return names[rng.Intn(n)]
+		}
+	}
+
+	var combos []Combination
+	for i, name := range names {
+		count := rng.Intn(4)
+		if rng.Intn(10) == 0 {
+			count = 20 + rng.Intn(30)
+		}
+		for range count {
+			combos = append(combos, Combination{Root: name, Left: ingredient(i), Right: ingredient(i), Tier: tiers[i]})
+		}
+	}
+	return combos
+}
+
+func TestRecipesOnRandomGraphs(t *testing.T) {
+	loadDataset(t)
+	defer func(capacity int64) { RecipeCacheBytes = capacity }(RecipeCacheBytes)
+	RecipeCacheBytes = 0
+	recipeCache.Reset()
+
+	rounds := 100
+	if testing.Short() {
+		rounds = 10
+	}
+	for seed := int64(1); seed <= int64(rounds); seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		useDataset(t, randomDataset(rng, 5+rng.Intn(40)))
+		for _, policy := range []string{"strict", "lte", "none"} {
+			if !t.Run(fmt.Sprintf("seed=%d/%s", seed, policy), func(t *testing.T) {
+				checkAlgorithms(t, policy)
+			}) {
+				return
+			}
+		}
+	}
+}
+
+// randomTree builds a tree over a few names, including ones with the
+// parentheses serializeTree writes, so that equal and confusable trees come
+// up often.
+func randomTree(rng *rand.Rand, depth int) *Node {
+	names := []string{"A", "B", "A(B", "B)", "(", ")", "A)(B"}
+	n := &Node{Element: names[rng.Intn(len(names))]}
+	if depth > 0 && rng.Intn(3) > 0 {
+		n.Left = randomTree(rng, depth-1)
+		n.Right = randomTree(rng, depth-1)
+	}
+	return n
+}
+
+// mirror returns n with the ingredients of some combinations swapped.
+func mirror(rng *rand.Rand, n *Node) *Node {
+	if n == nil {
+		return nil
+	}
+	m := &Node{Element: n.Element, Left: mirror(rng, n.Left), Right: mirror(rng, n.Right)}
+	if rng.Intn(2) == 0 {
+		m.Left, m.Right = m.Right, m.Left
+	}
+	return m
+}
+
+func TestSerializeTreeSignatures(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		a := randomTree(rng, 3)
+		if got, want := serializeTree(mirror(rng, a)), serializeTree(a); got != want {
+			t.Fatalf("mirrored tree serializes to %q, want %q", got, want)
+		}
+		b := randomTree(rng, 3)
+		if same, alike := sameRecipe(a, b), serializeTree(a) == serializeTree(b); same != alike {
+			t.Fatalf("trees %q and %q: same recipe %v, same signature %v", serializeTree(a), serializeTree(b), same, alike)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// recipeIDLength is the number of hex digits of a recipe ID.
+const recipeIDLength = 12
+
+// registryQueue is how many new entries may wait to be written before
+// Register blocks.
+const registryQueue = 1024
+
+// recipeRegistry remembers every recipe an ID was handed out for, so that
+// /recipes/{id} can rebuild it. Recipes are never forgotten: new entries are
+// appended to a file by a background writer and reloaded at startup, which
+// keeps shared links working across restarts.
+type recipeRegistry struct {
+	mu      sync.Mutex
+	recipes map[string]registryEntry
+	writes  chan registryEntry
+	written chan struct{}
+	sending sync.WaitGroup
+}
+
+var registry = &recipeRegistry{recipes: make(map[string]registryEntry)}
+
+type registryEntry struct {
+	ID     string     `json:"id"`
+	Recipe *indexTree `json:"recipe"`
+}
+
+// RecipeID derives a short stable ID from the canonical signature of n, so
+// the same recipe gets the same ID whatever the ingredient order and
+// whichever search found it.
+func RecipeID(n *Node) string {
+	sum := sha256.Sum256([]byte(serializeTree(n)))
+	return hex.EncodeToString(sum[:])[:recipeIDLength]
+}
+
+// LoadRecipeRegistry reads the recipes registered by earlier runs from
+// filename and starts appending new ones to it. A missing file is created.
+func LoadRecipeRegistry(filename string) error {
+	registry.Close()
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.recipes = make(map[string]registryEntry)
+	existing, err := os.Open(filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var entry registryEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				existing.Close()
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+			registry.recipes[entry.ID] = entry
+		}
+		existing.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	registry.writes = make(chan registryEntry, registryQueue)
+	registry.written = make(chan struct{})
+	go persistRecipes(file, registry.writes, registry.written)
+	return nil
+}
+
+// persistRecipes appends the entries sent on writes to file. It flushes
+// whenever the queue runs empty, so a burst of registrations becomes one
+// write, and closes file and written once writes is closed.
+func persistRecipes(file *os.File, writes <-chan registryEntry, written chan<- struct{}) {
+	defer close(written)
+	defer file.Close()
+
+	buffered := bufio.NewWriter(file)
+	for entry := range writes {
+		line, _ := json.Marshal(entry)
+		buffered.Write(append(line, '\n'))
+		if len(writes) == 0 {
+			if err := buffered.Flush(); err != nil {
+				logger.Error("saving recipes", "file", file.Name(), "error", err)
+			}
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		logger.Error("saving recipes", "file", file.Name(), "error", err)
+	}
+}
+
+// Close stops persisting new recipes and waits until the queued ones are
+// written. Registered recipes stay available in memory.
+func (reg *recipeRegistry) Close() {
+	reg.mu.Lock()
+	writes, written := reg.writes, reg.written
+	reg.writes, reg.written = nil, nil
+	reg.mu.Unlock()
+
+	if writes != nil {
+		reg.sending.Wait()
+		close(writes)
+		<-written
+	}
+}
+
+// Register records n under its ID and returns the ID. New recipes are queued
+// for the background writer after reg.mu is released, so a full queue only
+// blocks the caller and not every other Register and Lookup.
+func (reg *recipeRegistry) Register(n *Node) string {
+	id := RecipeID(n)
+
+	reg.mu.Lock()
+	if _, exists := reg.recipes[id]; exists {
+		reg.mu.Unlock()
+		return id
+	}
+	entry := registryEntry{ID: id, Recipe: toIndexTree(n)}
+	reg.recipes[id] = entry
+	writes := reg.writes
+	if writes != nil {
+		reg.sending.Add(1)
+	}
+	reg.mu.Unlock()
+
+	if writes != nil {
+		writes <- entry
+		reg.sending.Done()
+	}
+	return id
+}
+
+// Lookup returns the recipe registered under id.
+func (reg *recipeRegistry) Lookup(id string) (*Node, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	entry, ok := reg.recipes[id]
+	if !ok {
+		return nil, false
+	}
+	return entry.Recipe.node(), true
+}
+
+// validateRecipe checks a recipe against the current dataset: every leaf must
+// be a basic element, and every other node must be made from its children
+// by a combination the dataset lists and cons allows.
+func validateRecipe(n *Node, cons *Constraints) error {
+	if n == nil {
+		return errors.New("empty recipe")
+	}
+	if n.Left == nil && n.Right == nil {
+		if !isBasic(n.Element) {
+			return fmt.Errorf("%s is not a basic element", n.Element)
+		}
+		return nil
+	}
+	if n.Left == nil || n.Right == nil {
+		return fmt.Errorf("%s needs two ingredients", n.Element)
+	}
+
+	combos := matchingCombos(n.Element, n.Left.Element, n.Right.Element)
+	for _, c := range combos {
+		if cons.Allows(c) {
+			if err := validateRecipe(n.Left, cons); err != nil {
+				return err
+			}
+			return validateRecipe(n.Right, cons)
+		}
+	}
+	if len(combos) > 0 {
+		return fmt.Errorf("%s + %s = %s is not allowed", n.Left.Element, n.Right.Element, n.Element)
+	}
+	return fmt.Errorf("no combination makes %s from %s and %s", n.Element, n.Left.Element, n.Right.Element)
+}
+
+// matchingCombos returns the combinations of the dataset that make result
+// from left and right, in either order.
+func matchingCombos(result, left, right string) []Combination {
+	key := comboKey(left, right)
+	var matches []Combination
+	for _, c := range combinations[result] {
+		if comboKey(c.Left, c.Right) == key {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+func handleRecipe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	recipe, ok := registry.Lookup(id)
+	if !ok {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+
+	cons, err := ParseConstraints(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		ID     string `json:"id"`
+		Valid  bool   `json:"valid"`
+		Error  string `json:"error,omitempty"`
+		Path   []Step `json:"path"`
+		Target struct {
+			Element string `json:"element"`
+			Tier    int    `json:"tier"`
+		} `json:"target"`
+	}{ID: id, Valid: true, Path: convertRecipeToPath(recipe)}
+	response.Target.Element = recipe.Element
+	response.Target.Tier = tierMap[recipe.Element]
+	if err := validateRecipe(recipe, cons); err != nil {
+		response.Valid = false
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
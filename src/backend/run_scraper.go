@@ -1,9 +0,0 @@
-package main
-
-import (
-	"littlealchemy/Tubes2_SOS/src/backend"
-)
-
-func main() {
-	backend.Scraper()
-}
\ No newline at end of file
@@ -2,13 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -34,7 +42,13 @@ var MultiVisitedCount int32
 var DFSVisitedCount int
 var BidirectionalVisitedCount int
 var reverseMap map[string][]string
+var ingredientMap map[string][]Combination
 
+// LoadCombinations replaces the dataset with the one in filename. Every
+// entry needs a result, either both ingredients or neither (basic elements
+// are listed without any), and a non-negative tier shared by all entries of
+// its result. A file that breaks these rules is rejected as a whole and the
+// loaded dataset is kept.
 func LoadCombinations(filename string) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -46,10 +60,28 @@ func LoadCombinations(filename string) error {
 	if err := json.Unmarshal(data, &raw); err != nil {
 		return err
 	}
+	tiers := make(map[string]int)
+	for i, c := range raw {
+		switch tier, seen := tiers[c.Root]; {
+		case c.Root == "":
+			return fmt.Errorf("entry %d: missing root", i)
+		case (c.Left == "") != (c.Right == ""):
+			return fmt.Errorf("entry %d (%s): missing ingredient", i, c.Root)
+		case c.Tier < 0:
+			return fmt.Errorf("entry %d (%s): negative tier %d", i, c.Root, c.Tier)
+		case seen && tier != c.Tier:
+			return fmt.Errorf("entry %d (%s): tier %d, earlier entries say %d", i, c.Root, c.Tier, tier)
+		}
+		tiers[c.Root] = c.Tier
+	}
+
+	sum := sha256.Sum256(data)
+	datasetHash = hex.EncodeToString(sum[:])
 
 	combinations = make(map[string][]Combination)
 	tierMap = make(map[string]int)
 	reverseMap = make(map[string][]string)
+	ingredientMap = make(map[string][]Combination)
 
 	for _, c := range raw {
 		combinations[c.Root] = append(combinations[c.Root], c)
@@ -57,6 +89,11 @@ func LoadCombinations(filename string) error {
 
 		reverseMap[c.Left] = append(reverseMap[c.Left], c.Root)
 		reverseMap[c.Right] = append(reverseMap[c.Right], c.Root)
+
+		ingredientMap[c.Left] = append(ingredientMap[c.Left], c)
+		if c.Right != c.Left {
+			ingredientMap[c.Right] = append(ingredientMap[c.Right], c)
+		}
 	}
 	return nil
 }
@@ -77,50 +114,120 @@ type RecipePath struct {
 	Right string
 }
 
-func FindRecipeBFS(target string) *Node {
-	fmt.Printf("\n=== Starting BFS search for: %s ===\n", target)
-	
+// FindRecipeBFS finds one recipe for target in two passes: a breadth-first
+// walk collects every element below target, then recipes are built bottom-up
+// until nothing changes. Ties are broken the same way every time: the walk
+// queues combinations by descending tier gap, keeping dataset order among
+// equals, and the build pass goes through elements in the order the walk
+// reached them, giving each the first buildable combination in dataset
+// order.
+func FindRecipeBFS(target string, cons *Constraints) *Node {
+	result, visited := findRecipeBFS(context.Background(), target, cons, nil)
+	BFSVisitedCount = visited
+	return result
+}
+
+// findRecipeBFS is FindRecipeBFS with its visited count returned instead of
+// stored globally, so calls can run concurrently. Progress is reported to
+// observe, and the search stops early when ctx is done.
+func findRecipeBFS(ctx context.Context, target string, cons *Constraints, observe SearchObserver) (*Node, int) {
+	search := newBFSSearch(ctx, target, cons, observe)
+	for ctx.Err() == nil && search.Step() {
+	}
+	return search.Result(), search.visitedCount
+}
+
+// bfsSearch is FindRecipeBFS as a state machine: each Step visits one queued
+// element during the walk, or tries to build one element's recipe during the
+// build pass.
+type bfsSearch struct {
+	target  string
+	cons    *Constraints
+	observe SearchObserver
+	log     *searchLog
+
+	visited      map[string]bool
+	order        []string
+	recipeMap    map[string]*Node
+	queue        []string
+	visitedCount int
+
+	building bool
+	next     int
+	changed  bool
+	finished bool
+	result   *Node
+}
+
+// newBFSSearch prepares a BFS for target. Targets that need no search, or
+// cannot be searched, leave it finished already.
+func newBFSSearch(ctx context.Context, target string, cons *Constraints, observe SearchObserver) *bfsSearch {
+	log := newSearchLog(ctx, "bfs", "single", target)
+	log.debug("search started")
+	s := &bfsSearch{target: target, cons: cons, observe: observe, log: log, finished: true}
+
+	if !cons.AllowsElement(target) {
+		log.debug("target excluded")
+		return s
+	}
+
 	if isBasic(target) {
-		fmt.Printf("Found basic element: %s\n", target)
-		BFSVisitedCount = 1
-		return &Node{Element: target}
+		log.debug("target is basic")
+		s.visitedCount = 1
+		s.visited = map[string]bool{target: true}
+		s.order = []string{target}
+		s.result = &Node{Element: target}
+		return s
 	}
 
 	if _, exists := combinations[target]; !exists {
-		fmt.Printf("Element %s not found in combinations\n", target)
-		BFSVisitedCount = 0
-		return nil
+		log.debug("target not in dataset")
+		return s
 	}
 
-	fmt.Printf("Found %d combinations for %s\n", len(combinations[target]), target)
-	visited := make(map[string]bool)
-	recipeMap := make(map[string]*Node)
-	queue := []string{target}
-	BFSVisitedCount = 0
+	s.visited = make(map[string]bool)
+	s.recipeMap = make(map[string]*Node)
+	s.queue = []string{target}
+	s.finished = false
 
-	fmt.Println("\nFirst pass: Collecting combinations...")
-	for len(queue) > 0 {
-		current := queue[0]
-		queue = queue[1:]
-		if visited[current] {
+	return s
+}
+
+// Step advances the search by one element and reports whether there is more
+// to do.
+func (s *bfsSearch) Step() bool {
+	if s.finished {
+		return false
+	}
+	if !s.building {
+		s.collect()
+	} else {
+		s.build()
+	}
+	return !s.finished
+}
+
+// collect visits the next unvisited element in the queue. Once the queue is
+// empty the build pass starts.
+func (s *bfsSearch) collect() {
+	for len(s.queue) > 0 {
+		current := s.queue[0]
+		s.queue = s.queue[1:]
+		if s.visited[current] {
 			continue
 		}
-		visited[current] = true
-		BFSVisitedCount++
-		fmt.Printf("Visiting: %s (visited count: %d)\n", current, BFSVisitedCount)
+		s.visited[current] = true
+		s.order = append(s.order, current)
+		s.visitedCount++
+		s.observe.emit(SearchEvent{Type: EventVisit, Search: s.target, Element: current, Visited: s.visitedCount, Frontier: len(s.queue)})
 
 		if isBasic(current) {
-			fmt.Printf("Found basic element: %s\n", current)
-			recipeMap[current] = &Node{Element: current}
-			continue
+			s.recipeMap[current] = &Node{Element: current}
+			return
 		}
 
-		validCombos := []Combination{}
-		for _, comb := range combinations[current] {
-			if tierMap[comb.Left] < tierMap[current] && tierMap[comb.Right] < tierMap[current] {
-				validCombos = append(validCombos, comb)
-			}
-		}
+		s.observe.skipped(s.target, current, s.cons)
+		validCombos := allowedCombos(current, s.cons)
 
 		sort.SliceStable(validCombos, func(i, j int) bool {
 			iDiff := (tierMap[current] - tierMap[validCombos[i].Left]) + (tierMap[current] - tierMap[validCombos[i].Right])
@@ -129,120 +236,412 @@ func FindRecipeBFS(target string) *Node {
 		})
 
 		for _, comb := range validCombos {
-			fmt.Printf("  Checking combination: %s (tier %d) + %s (tier %d) = %s (tier %d)\n", 
-				comb.Left, tierMap[comb.Left], comb.Right, tierMap[comb.Right], comb.Root, comb.Tier)
-			
-			if !visited[comb.Left] {
-				queue = append(queue, comb.Left)
-				fmt.Printf("    Added to queue: %s\n", comb.Left)
+			if !s.visited[comb.Left] {
+				s.queue = append(s.queue, comb.Left)
+				s.observe.emit(SearchEvent{Type: EventPush, Search: s.target, Element: comb.Left, Parent: current})
 			}
-			if !visited[comb.Right] {
-				queue = append(queue, comb.Right)
-				fmt.Printf("    Added to queue: %s\n", comb.Right)
+			if !s.visited[comb.Right] {
+				s.queue = append(s.queue, comb.Right)
+				s.observe.emit(SearchEvent{Type: EventPush, Search: s.target, Element: comb.Right, Parent: current})
 			}
 		}
+		return
 	}
 
-	fmt.Println("\nSecond pass: Building recipes...")
-	changed := true
-	for changed {
-		changed = false
-		for elem := range visited {
-			if recipeMap[elem] != nil {
+	s.building = true
+	s.build()
+}
+
+// build tries to give the next element without a recipe, in the order the
+// walk reached them, its first buildable combination. The pass sweeps the
+// elements again as long as the previous sweep built something.
+func (s *bfsSearch) build() {
+	for {
+		for s.next < len(s.order) {
+			elem := s.order[s.next]
+			s.next++
+			if s.recipeMap[elem] != nil {
 				continue
 			}
 
-			for _, comb := range combinations[elem] {
-				if tierMap[comb.Left] < tierMap[elem] && tierMap[comb.Right] < tierMap[elem] {
-					leftRecipe := recipeMap[comb.Left]
-					rightRecipe := recipeMap[comb.Right]
+			for _, comb := range s.cons.ordered(combinations[elem]) {
+				if s.cons.Allows(comb) {
+					leftRecipe := s.recipeMap[comb.Left]
+					rightRecipe := s.recipeMap[comb.Right]
 					if leftRecipe != nil && rightRecipe != nil {
-						fmt.Printf("Found recipe for %s: %s + %s\n", 
-							elem, comb.Left, comb.Right)
-						recipeMap[elem] = &Node{
+						s.observe.emit(SearchEvent{Type: EventRecipe, Search: s.target, Element: elem, Left: comb.Left, Right: comb.Right})
+						s.recipeMap[elem] = &Node{
 							Element: elem,
 							Left:    leftRecipe,
 							Right:   rightRecipe,
 						}
-						changed = true
+						s.changed = true
 						break
 					}
 				}
 			}
+			return
 		}
+
+		if !s.changed {
+			s.finish()
+			return
+		}
+		s.changed = false
+		s.next = 0
 	}
+}
 
-	result := recipeMap[target]
-	if result != nil {
-		fmt.Printf("\nSuccessfully found recipe for %s\n", target)
-	} else {
-		fmt.Printf("\nNo valid recipe found for %s\n", target)
+func (s *bfsSearch) finish() {
+	s.finished = true
+	s.result = s.recipeMap[s.target]
+	s.log.debug("search finished", slog.Bool("found", s.result != nil), slog.Int("visited", s.visitedCount))
+}
+
+// Result returns the recipe found, or nil.
+func (s *bfsSearch) Result() *Node {
+	return s.result
+}
+
+// Snapshot reports the queue and the elements visited so far.
+func (s *bfsSearch) Snapshot() SearchSnapshot {
+	phase := "collect"
+	if s.building {
+		phase = "build"
+	}
+	return SearchSnapshot{
+		Phase:        phase,
+		Frontier:     append([]string{}, s.queue...),
+		Visited:      append([]string{}, s.order...),
+		VisitedCount: s.visitedCount,
+		Done:         s.finished,
+		Found:        s.result != nil,
 	}
+}
+
+// FindRecipeDFS finds one recipe for target depth-first. A nil visited
+// starts a fresh search; otherwise the search shares visited with earlier
+// ones and DFSVisitedCount keeps counting from where they left it.
+func FindRecipeDFS(target string, visited map[string]bool, cons *Constraints) *Node {
+	if visited == nil {
+		DFSVisitedCount = 0
+	}
+	result, count := findRecipeDFS(context.Background(), target, visited, cons, nil)
+	DFSVisitedCount += count
 	return result
 }
 
-func FindRecipeDFS(target string, visited map[string]bool) *Node {
-	if _, exists := combinations[target]; !exists && !isBasic(target) {
+// findRecipeDFS is FindRecipeDFS with the number of elements this call
+// visited returned instead of stored globally, so calls can run
+// concurrently. Progress is reported to observe, and the search stops early
+// when ctx is done.
+func findRecipeDFS(ctx context.Context, target string, visited map[string]bool, cons *Constraints, observe SearchObserver) (*Node, int) {
+	if visited == nil {
+		visited = make(map[string]bool)
+	}
+
+	search := newDFSSearch(target, visited, cons, observe)
+	for ctx.Err() == nil && search.Step() {
+	}
+	return search.Result(), search.visitedCount
+}
+
+// dfsSearch holds the state of one FindRecipeDFS call. Recipes found for an
+// element are valid wherever it appears, so they are kept in found. A failed
+// element is kept in failed only if the failure did not depend on an
+// ancestor being on the current path.
+//
+// Tiers keep the strict policy acyclic. Under other policies the search only
+// follows combinations whose ingredients have a lower craft rank than the
+// result, which plays the same role; without it, failures inside a cycle
+// depend on the path and the search re-explores them exponentially often.
+//
+// The recursion is kept on an explicit stack of frames so that the search
+// can stop after any visit and resume later.
+type dfsSearch struct {
+	visited map[string]bool
+	path    map[string]int
+	found   map[string]*Node
+	failed  map[string]bool
+	ranks   map[string]int
+	cons    *Constraints
+	target  string
+	observe SearchObserver
+
+	stack        []*dfsFrame
+	expanded     []string
+	visitedCount int
+	started      bool
+	finished     bool
+
+	// The answer of the innermost call that returned, waiting to be handed
+	// to the frame below it.
+	ret     *Node
+	retLow  int
+	pending bool
+}
+
+// dfsFrame is one element being expanded, trying combos, the combinations
+// of elem in the order cons.ordered gives them. next is the index of the
+// next combination to try; while the ingredients of combos[next-1]
+// are searched, waiting says which one (dfsLeft or dfsRight) and left holds
+// the left recipe once found. low is as in find.
+type dfsFrame struct {
+	elem    string
+	combos  []Combination
+	depth   int
+	next    int
+	waiting int
+	left    *Node
+	low     int
+}
+
+const (
+	dfsNone = iota
+	dfsLeft
+	dfsRight
+)
+
+func newDFSSearch(target string, visited map[string]bool, cons *Constraints, observe SearchObserver) *dfsSearch {
+	s := &dfsSearch{
+		visited: visited,
+		path:    make(map[string]int),
+		found:   make(map[string]*Node),
+		failed:  make(map[string]bool),
+		cons:    cons,
+		target:  target,
+		observe: observe,
+	}
+	if !cons.policy().TierBounded() {
+		s.ranks = craftRanks(cons)
+	}
+	return s
+}
+
+// lowerRank reports whether both ingredients of c have a lower craft rank
+// than its result.
+func lowerRank(ranks map[string]int, c Combination) bool {
+	root, rootOK := ranks[c.Root]
+	left, leftOK := ranks[c.Left]
+	right, rightOK := ranks[c.Right]
+	return rootOK && leftOK && rightOK && left < root && right < root
+}
+
+// noCut is the low value of a search that never ran into the current path.
+const noCut = math.MaxInt
+
+// Step runs the search up to and including its next visit and reports
+// whether there is more to do.
+func (s *dfsSearch) Step() bool {
+	if s.finished {
+		return false
+	}
+	if !s.started {
+		s.started = true
+		if s.find(s.target) {
+			return !s.finished
+		}
+	}
+	for !s.finished {
+		if s.advance() {
+			break
+		}
+	}
+	return !s.finished
+}
+
+// Result returns the recipe found, or nil.
+func (s *dfsSearch) Result() *Node {
+	if !s.finished {
 		return nil
 	}
+	return s.ret
+}
+
+// find starts the search for a recipe for target. Answers known without
+// expanding target are left pending for the caller at once; otherwise a
+// frame is pushed. It reports whether target was visited.
+//
+// A pending answer carries low: the shallowest path position, above target,
+// at which the search ran into an element already on the path; elements in
+// the caller-supplied visited map sit above the root.
+func (s *dfsSearch) find(target string) bool {
+	if _, exists := combinations[target]; !exists && !isBasic(target) {
+		s.answer(nil, noCut)
+		return false
+	}
+
+	if !s.cons.AllowsElement(target) {
+		s.answer(nil, noCut)
+		return false
+	}
 
 	if isBasic(target) {
-		DFSVisitedCount++
-		return &Node{Element: target}
+		s.visit(target)
+		s.answer(&Node{Element: target}, noCut)
+		return true
 	}
 
-	if visited == nil {
-		visited = make(map[string]bool)
-		DFSVisitedCount = 0
+	if s.found[target] != nil {
+		s.answer(s.found[target], noCut)
+		return false
+	}
+	if s.failed[target] {
+		s.answer(nil, noCut)
+		return false
+	}
+	if depth, onPath := s.path[target]; onPath {
+		s.answer(nil, depth)
+		return false
+	}
+	if s.visited[target] {
+		s.answer(nil, -1)
+		return false
 	}
 
-	if visited[target] {
-		return nil
+	depth := len(s.path)
+	s.path[target] = depth
+	s.stack = append(s.stack, &dfsFrame{elem: target, combos: s.cons.ordered(combinations[target]), depth: depth, low: noCut})
+	s.visit(target)
+	return true
+}
+
+func (s *dfsSearch) visit(elem string) {
+	s.visitedCount++
+	s.expanded = append(s.expanded, elem)
+	s.observe.emit(SearchEvent{Type: EventVisit, Search: s.target, Element: elem, Visited: s.visitedCount, Frontier: len(s.path)})
+}
+
+func (s *dfsSearch) skip(c Combination, reason string) {
+	s.observe.emit(SearchEvent{Type: EventSkip, Search: s.target, Element: c.Root, Left: c.Left, Right: c.Right, Reason: reason})
+}
+
+func (s *dfsSearch) answer(node *Node, low int) {
+	s.ret, s.retLow, s.pending = node, low, true
+	if len(s.stack) == 0 {
+		s.finished = true
 	}
+}
 
-	visited[target] = true
-	DFSVisitedCount++
-	defer func() { visited[target] = false }()
+// pop ends the innermost frame with the given answer.
+func (s *dfsSearch) pop(node *Node, low int) {
+	frame := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	delete(s.path, frame.elem)
+	s.answer(node, low)
+}
 
-	for _, comb := range combinations[target] {
-		if tierMap[comb.Left] < tierMap[target] && tierMap[comb.Right] < tierMap[target] {
-			left := FindRecipeDFS(comb.Left, visited)
-			if left == nil {
-				continue
-			}
-			right := FindRecipeDFS(comb.Right, visited)
-			if right != nil {
-				return &Node{Element: target, Left: left, Right: right}
-			}
+// advance does the next piece of work of the innermost frame and reports
+// whether it visited an element.
+func (s *dfsSearch) advance() bool {
+	frame := s.stack[len(s.stack)-1]
+
+	if s.pending {
+		s.pending = false
+		node, low := s.ret, s.retLow
+		frame.low = min(frame.low, low)
+		comb := frame.combos[frame.next-1]
+		switch {
+		case node == nil:
+			frame.waiting = dfsNone
+		case frame.waiting == dfsLeft:
+			frame.left = node
+			frame.waiting = dfsRight
+			return s.find(comb.Right)
+		default:
+			s.observe.emit(SearchEvent{Type: EventRecipe, Search: s.target, Element: frame.elem, Left: comb.Left, Right: comb.Right})
+			s.found[frame.elem] = &Node{Element: frame.elem, Left: frame.left, Right: node}
+			s.pop(s.found[frame.elem], noCut)
+			return false
 		}
 	}
 
-	return nil
+	for frame.next < len(frame.combos) {
+		comb := frame.combos[frame.next]
+		frame.next++
+		if reason := s.cons.rejection(comb); reason != "" {
+			s.skip(comb, reason)
+			continue
+		}
+		if s.ranks != nil && !lowerRank(s.ranks, comb) {
+			s.skip(comb, "craft_rank")
+			continue
+		}
+		frame.waiting = dfsLeft
+		return s.find(comb.Left)
+	}
+
+	if frame.low >= frame.depth {
+		s.failed[frame.elem] = true
+		s.pop(nil, noCut)
+	} else {
+		s.pop(nil, frame.low)
+	}
+	return false
+}
+
+// Snapshot reports the current path and the elements visited so far.
+func (s *dfsSearch) Snapshot() SearchSnapshot {
+	frontier := make([]string, len(s.stack))
+	for i, frame := range s.stack {
+		frontier[i] = frame.elem
+	}
+	return SearchSnapshot{
+		Frontier:     frontier,
+		Visited:      append([]string{}, s.expanded...),
+		VisitedCount: s.visitedCount,
+		Done:         s.finished,
+		Found:        s.Result() != nil,
+	}
 }
 
-func FindMultipleRecipesDFS(target string) []*Node {
-	fmt.Printf("\n=== Starting Multiple DFS search for: %s ===\n", target)
-	
+func FindMultipleRecipesDFS(target string, cons *Constraints) []*Node {
+	results, visited := multipleRecipesDFS(context.Background(), target, cons, 0, nil)
+	DFSVisitedCount = visited
+	return results
+}
+
+// multipleRecipesDFS is FindMultipleRecipesDFS with its visited count
+// returned instead of stored globally, so calls can run concurrently. It
+// stops when ctx is done and keeps at most limit recipes per element when
+// limit > 0. Progress is reported to observe.
+//
+// The recipes of an element are memoized, which is only sound if they do
+// not depend on the ancestors on the current path. Under policies that are
+// not tier-bounded, the search therefore follows only combinations that
+// lower the craft rank, as dfsSearch does, so that it never runs into the
+// path.
+func multipleRecipesDFS(ctx context.Context, target string, cons *Constraints, limit int, observe SearchObserver) ([]*Node, int) {
+	log := newSearchLog(ctx, "dfs", "multiple", target)
+	log.debug("search started")
+
+	if !cons.AllowsElement(target) {
+		log.debug("target excluded")
+		return nil, 0
+	}
+
 	if isBasic(target) {
-		fmt.Printf("Found basic element: %s\n", target)
-		DFSVisitedCount = 1
-		return []*Node{{Element: target}}
+		log.debug("target is basic")
+		return []*Node{{Element: target}}, 1
 	}
 
 	if _, exists := combinations[target]; !exists {
-		fmt.Printf("Element %s not found in combinations\n", target)
-		DFSVisitedCount = 0
-		return nil
+		log.debug("target not in dataset")
+		return nil, 0
 	}
 
 	visited := make(map[string]bool)
 	recipeMap := make(map[string][]*Node)
-	DFSVisitedCount = 0
+	visitedCount := 0
+	var ranks map[string]int
+	if !cons.policy().TierBounded() {
+		ranks = craftRanks(cons)
+	}
 
 	var findRecipes func(elem string) []*Node
 	findRecipes = func(elem string) []*Node {
 		if isBasic(elem) {
-			DFSVisitedCount++
+			visitedCount++
 			return []*Node{{Element: elem}}
 		}
 
@@ -251,7 +650,8 @@ func FindMultipleRecipesDFS(target string) []*Node {
 		}
 
 		visited[elem] = true
-		DFSVisitedCount++
+		visitedCount++
+		observe.emit(SearchEvent{Type: EventVisit, Search: target, Element: elem, Visited: visitedCount})
 		defer func() { visited[elem] = false }()
 
 		if recipes, exists := recipeMap[elem]; exists {
@@ -259,27 +659,38 @@ func FindMultipleRecipesDFS(target string) []*Node {
 		}
 
 		var recipes []*Node
-		for _, comb := range combinations[elem] {
-			if tierMap[comb.Left] < tierMap[elem] && tierMap[comb.Right] < tierMap[elem] {
-				leftRecipes := findRecipes(comb.Left)
-				if len(leftRecipes) == 0 {
-					continue
-				}
-				rightRecipes := findRecipes(comb.Right)
-				if len(rightRecipes) == 0 {
-					continue
-				}
+		for _, comb := range cons.ordered(combinations[elem]) {
+			if ctx.Err() != nil {
+				break
+			}
+			if reason := cons.rejection(comb); reason != "" {
+				observe.emit(SearchEvent{Type: EventSkip, Search: target, Element: elem, Left: comb.Left, Right: comb.Right, Reason: reason})
+				continue
+			}
+			if ranks != nil && !lowerRank(ranks, comb) {
+				observe.emit(SearchEvent{Type: EventSkip, Search: target, Element: elem, Left: comb.Left, Right: comb.Right, Reason: "craft_rank"})
+				continue
+			}
+			leftRecipes := findRecipes(comb.Left)
+			if len(leftRecipes) == 0 {
+				continue
+			}
+			rightRecipes := findRecipes(comb.Right)
+			if len(rightRecipes) == 0 {
+				continue
+			}
 
-				for _, left := range leftRecipes {
-					for _, right := range rightRecipes {
-						fmt.Printf("Found recipe for %s: %s + %s\n", 
-							elem, comb.Left, comb.Right)
-						recipes = append(recipes, &Node{
-							Element: elem,
-							Left:    left,
-							Right:   right,
-						})
+			for _, left := range leftRecipes {
+				for _, right := range rightRecipes {
+					if limit > 0 && len(recipes) >= limit {
+						break
 					}
+					observe.emit(SearchEvent{Type: EventRecipe, Search: target, Element: elem, Left: comb.Left, Right: comb.Right})
+					recipes = append(recipes, &Node{
+						Element: elem,
+						Left:    left,
+						Right:   right,
+					})
 				}
 			}
 		}
@@ -289,310 +700,309 @@ func FindMultipleRecipesDFS(target string) []*Node {
 	}
 
 	results := findRecipes(target)
-	if len(results) > 0 {
-		fmt.Printf("\nSuccessfully found %d recipes for %s\n", len(results), target)
-	} else {
-		fmt.Printf("\nNo valid recipe found for %s\n", target)
-	}
-	return results
+	log.debug("search finished", slog.Int("recipes", len(results)), slog.Int("visited", visitedCount))
+	return results, visitedCount
 }
 
-func FindMultipleRecipesBidirectional(target string) []*Node {
-	fmt.Printf("\n=== Starting Multiple Bidirectional Search ===\n")
-	fmt.Printf("Target: %s (Tier: %d)\n", target, tierMap[target])
-	basics := getSortedBasicElements()
-	fmt.Printf("Start Elements: %v\n", basics)
+// MultiWorkers is the size of the worker pool FindMultipleRecipes fans out
+// to, set from the MULTI_WORKERS environment variable. Zero means
+// runtime.GOMAXPROCS(0).
+var MultiWorkers = 0
 
-	if isBasic(target) {
-		fmt.Printf("Target is a basic element, returning direct node\n")
-		return []*Node{{Element: target}}
-	}
-	if _, exists := combinations[target]; !exists {
-		fmt.Printf("Target element not found in combinations\n")
-		return nil
-	}
+// workerPool bounds how many goroutines a search runs at once. When every
+// slot is taken, Go runs the task in the calling goroutine instead of
+// waiting, so tasks can fan out further without deadlocking the pool.
+type workerPool struct {
+	slots chan struct{}
+}
 
-	forwardVisited := make(map[string][]*Node)
-	forwardQueue := []string{}
-	for _, b := range basics {
-		forwardVisited[b] = []*Node{{Element: b}}
-		forwardQueue = append(forwardQueue, b)
+func newWorkerPool(size int) *workerPool {
+	if size < 1 {
+		size = runtime.GOMAXPROCS(0)
 	}
-	
-	backwardVisited := make(map[string]bool)
-	backwardQueue := []string{target}
-	backwardVisited[target] = true
-	
-	BidirectionalVisitedCount = len(basics) + 1
-	fmt.Printf("Initialized bidirectional search\n")
+	return &workerPool{slots: make(chan struct{}, size)}
+}
 
-	var results []*Node
-	for len(forwardQueue) > 0 && len(backwardQueue) > 0 {
-		currentForward := forwardQueue[0]
-		forwardQueue = forwardQueue[1:]
-		fmt.Printf("\nForward exploring from: %s (Tier: %d)\n", currentForward, tierMap[currentForward])
-
-		if backwardVisited[currentForward] {
-			fmt.Printf("Found intersection at: %s\n", currentForward)
-			forwardPaths := forwardVisited[currentForward]
-			
-			for _, comb := range combinations[target] {
-				if comb.Left == currentForward || comb.Right == currentForward {
-					var otherElement string
-					if comb.Left == currentForward {
-						otherElement = comb.Right
-					} else {
-						otherElement = comb.Left
-					}
-					
-					if otherNodes, exists := forwardVisited[otherElement]; exists {
-						for _, forwardPath := range forwardPaths {
-							for _, otherNode := range otherNodes {
-								results = append(results, &Node{
-									Element: target,
-									Left:   forwardPath,
-									Right:  otherNode,
-								})
-							}
-						}
-					}
-				}
-			}
-		}
+func (p *workerPool) Go(wg *sync.WaitGroup, task func()) {
+	wg.Add(1)
+	select {
+	case p.slots <- struct{}{}:
+		go func() {
+			defer func() {
+				<-p.slots
+				wg.Done()
+			}()
+			task()
+		}()
+	default:
+		defer wg.Done()
+		task()
+	}
+}
 
-		for _, comb := range combinations {
-			for _, c := range comb {
-				if (c.Left == currentForward || c.Right == currentForward) &&
-					len(forwardVisited[c.Left]) > 0 && len(forwardVisited[c.Right]) > 0 &&
-					tierMap[c.Left] < tierMap[c.Root] && tierMap[c.Right] < tierMap[c.Root] {
-					
-					if _, exists := forwardVisited[c.Root]; !exists {
-						fmt.Printf("  Forward found: %s + %s = %s\n", c.Left, c.Right, c.Root)
-						for _, left := range forwardVisited[c.Left] {
-							for _, right := range forwardVisited[c.Right] {
-								forwardVisited[c.Root] = append(forwardVisited[c.Root], &Node{
-									Element: c.Root,
-									Left:    left,
-									Right:   right,
-								})
-							}
-						}
-						forwardQueue = append(forwardQueue, c.Root)
-						BidirectionalVisitedCount++
-					}
-				}
-			}
-		}
+// recipeEntry is one element's slot in FindMultipleRecipes' shared cache.
+// once makes concurrent workers that need the same ingredient wait for a
+// single computation instead of repeating it. complete is false if the
+// search was cut short by cancellation.
+type recipeEntry struct {
+	once     sync.Once
+	nodes    []*Node
+	visited  int
+	complete bool
+}
 
-		currentBackward := backwardQueue[0]
-		backwardQueue = backwardQueue[1:]
-		fmt.Printf("\nBackward exploring from: %s (Tier: %d)\n", currentBackward, tierMap[currentBackward])
-
-		for _, comb := range combinations {
-			for _, c := range comb {
-				if c.Root == currentBackward {
-					if !backwardVisited[c.Left] {
-						backwardVisited[c.Left] = true
-						backwardQueue = append(backwardQueue, c.Left)
-						BidirectionalVisitedCount++
-					}
-					if !backwardVisited[c.Right] {
-						backwardVisited[c.Right] = true
-						backwardQueue = append(backwardQueue, c.Right)
-						BidirectionalVisitedCount++
-					}
-				}
-			}
-		}
-	}
+// multiTask is the outcome of expanding one top-level combination in
+// FindMultipleRecipes.
+type multiTask struct {
+	done        bool
+	nodes       []*Node
+	examined    int
+	ingredients [2]*recipeEntry
+}
 
-	if len(results) > 0 {
-		fmt.Printf("\nSuccessfully found %d recipes for %s\n", len(results), target)
-	} else {
-		fmt.Printf("\nNo valid recipe found for %s\n", target)
-	}
+// FindMultipleRecipes returns up to maxCount distinct recipes for target.
+// Every valid top-level combination of target is a task on a worker pool of
+// MultiWorkers goroutines, and each task fans out again to find the
+// recipes of its two ingredients with algorithm. Ingredient recipes are
+// shared between tasks through ingredientEntries, and between requests
+// through recipeCache along with the final answer.
+//
+// The answer does not depend on scheduling. Finished tasks are merged in
+// dataset order of target's combinations, each contributing its recipes with
+// the left ingredient's recipes as the outer loop, and duplicates keep their
+// first position. Work stops through ctx once the merged prefix holds
+// maxCount recipes, or when the timeout expires. The merged recipes are then
+// stably sorted by depth, so equal-depth recipes keep their merge order. The
+// visited count covers the merged tasks only, counting each ingredient
+// search once.
+func FindMultipleRecipes(target string, maxCount int, algorithm string, cons *Constraints) []*Node {
+	results, visited, _ := findMultipleRecipes(context.Background(), target, maxCount, maxCount, algorithm, cons, nil)
+	atomic.StoreInt32(&MultiVisitedCount, int32(visited))
 	return results
 }
 
-func FindMultipleRecipes(target string, maxCount int, algorithm string) []*Node {
+// findMultipleRecipes is FindMultipleRecipes with a separate limit on how
+// many recipes each ingredient search and each top-level combination may
+// contribute, so that a larger pool can be spread over more combinations.
+// With an observer, progress is reported to it and the recipe cache is
+// bypassed, so that the work actually happens. The visited count is
+// returned rather than stored in MultiVisitedCount, so calls can run
+// concurrently. The search stops with parent's error when parent is done;
+// otherwise the error is FindConstrainedRecipe's, when it falls back on it
+// and it gives up.
+func findMultipleRecipes(parent context.Context, target string, maxCount, perCombo int, algorithm string, cons *Constraints, observe SearchObserver) ([]*Node, int, error) {
+	if !cons.AllowsElement(target) {
+		return nil, 0, nil
+	}
+
 	if isBasic(target) {
-		atomic.StoreInt32(&MultiVisitedCount, 1)
-		return []*Node{{Element: target}}
+		return []*Node{{Element: target}}, 1, nil
 	}
 
 	if _, exists := combinations[target]; !exists {
-		return nil
+		return nil, 0, nil
 	}
-	atomic.StoreInt32(&MultiVisitedCount, 0)
+	key := recipeCacheKey("multiple:"+strconv.Itoa(perCombo), algorithm, target, maxCount, cons)
+	if observe == nil {
+		if cached, ok := recipeCache.Get(key); ok {
+			return cached.nodes, cached.visited, nil
+		}
+	}
+
+	combos := allowedCombos(target, cons)
+	tasks := make([]multiTask, len(combos))
+	merged := 0
 	var results []*Node
 	var mu sync.Mutex
 
-	recipeCache := sync.Map{}
-	seen := sync.Map{}
+	ingredientEntries := sync.Map{}
+	seen := make(map[string]bool)
+	pool := newWorkerPool(MultiWorkers)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(parent, 30*time.Second)
 	defer cancel()
 
-	var findRecipeWithAlgorithm func(elem string) []*Node
+	var findRecipeWithAlgorithm func(elem string) ([]*Node, int)
 	switch algorithm {
-	case "bfs":
-		findRecipeWithAlgorithm = func(elem string) []*Node {
-			if nodes := FindMultipleRecipesBFS(elem); nodes != nil {
-				atomic.AddInt32(&MultiVisitedCount, int32(GetBFSVisited()))
-				return nodes
-			}
-			return nil
-		}
 	case "dfs":
-		findRecipeWithAlgorithm = func(elem string) []*Node {
-			if nodes := FindMultipleRecipesDFS(elem); nodes != nil {
-				atomic.AddInt32(&MultiVisitedCount, int32(GetDFSVisited()))
-				return nodes
-			}
-			return nil
+		findRecipeWithAlgorithm = func(elem string) ([]*Node, int) {
+			return multipleRecipesDFS(ctx, elem, cons, perCombo, observe)
 		}
 	case "bidirectional":
-		findRecipeWithAlgorithm = func(elem string) []*Node {
-			if nodes := FindMultipleRecipesBidirectional(elem); nodes != nil {
-				atomic.AddInt32(&MultiVisitedCount, int32(GetBidirectionalVisited()))
-				return nodes
-			}
-			return nil
+		findRecipeWithAlgorithm = func(elem string) ([]*Node, int) {
+			return multipleRecipesBidirectional(ctx, elem, cons, perCombo, observe)
 		}
 	default:
-		findRecipeWithAlgorithm = func(elem string) []*Node {
-			if nodes := FindMultipleRecipesBFS(elem); nodes != nil {
-				atomic.AddInt32(&MultiVisitedCount, int32(GetBFSVisited()))
-				return nodes
-			}
-			return nil
+		findRecipeWithAlgorithm = func(elem string) ([]*Node, int) {
+			return multipleRecipesBFS(ctx, elem, cons, perCombo, observe)
 		}
 	}
 
-	var findAllCombinations func(elem string) [][]Combination
-	findAllCombinations = func(elem string) [][]Combination {
-		var allCombos [][]Combination
-		for _, c := range combinations[elem] {
-			if tierMap[c.Left] < tierMap[elem] && tierMap[c.Right] < tierMap[elem] {
-				allCombos = append(allCombos, []Combination{c})
+	ingredientRecipes := func(elem string) *recipeEntry {
+		value, _ := ingredientEntries.LoadOrStore(elem, &recipeEntry{})
+		entry := value.(*recipeEntry)
+		entry.once.Do(func() {
+			if isBasic(elem) {
+				entry.nodes, entry.visited, entry.complete = []*Node{{Element: elem}}, 1, true
+				return
 			}
-		}
-		return allCombos
+			key := recipeCacheKey("ingredient", algorithm, elem, perCombo, cons)
+			if observe == nil {
+				if cached, ok := recipeCache.Get(key); ok {
+					entry.nodes, entry.visited, entry.complete = cached.nodes, cached.visited, true
+					return
+				}
+			}
+			entry.nodes, entry.visited = findRecipeWithAlgorithm(elem)
+			entry.complete = ctx.Err() == nil
+			if entry.complete {
+				recipeCache.Put(key, cachedRecipes{nodes: entry.nodes, visited: entry.visited})
+			}
+		})
+		return entry
 	}
 
-	var findRecipe func(ctx context.Context, elem string, visited map[string]bool) []*Node
-	findRecipe = func(ctx context.Context, elem string, visited map[string]bool) []*Node {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-		}
-
-		if isBasic(elem) {
-			atomic.AddInt32(&MultiVisitedCount, 1)
-			return []*Node{{Element: elem}}
-		}
-
-		if visited == nil {
-			visited = make(map[string]bool)
+	// merge appends the recipes of finished tasks that directly follow the
+	// merged prefix, and cancels the remaining work once the prefix holds
+	// maxCount recipes. It must be called with mu held.
+	merge := func() {
+		for merged < len(tasks) && tasks[merged].done && len(results) < maxCount {
+			for _, node := range tasks[merged].nodes {
+				key := serializeTree(node)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				results = append(results, node)
+				observe.emit(SearchEvent{Type: EventRecipe, Search: target, Element: target, Left: node.Left.Element, Right: node.Right.Element})
+				if len(results) == maxCount {
+					break
+				}
+			}
+			merged++
 		}
-
-		if visited[elem] {
-			return nil
+		if len(results) >= maxCount {
+			cancel()
 		}
+	}
 
-		visited[elem] = true
-		defer func() { visited[elem] = false }()
-
-		if cached, ok := recipeCache.Load(elem); ok {
-			return cached.([]*Node)
+	expand := func(i int, c Combination) {
+		var task multiTask
+		var sides sync.WaitGroup
+		pool.Go(&sides, func() { task.ingredients[0] = ingredientRecipes(c.Left) })
+		pool.Go(&sides, func() { task.ingredients[1] = ingredientRecipes(c.Right) })
+		sides.Wait()
+		left, right := task.ingredients[0], task.ingredients[1]
+		if !left.complete || !right.complete {
+			return
 		}
 
-		var localResults []*Node
-	
-		allCombos := findAllCombinations(elem)
-
-		for _, comboGroup := range allCombos {
-			for _, c := range comboGroup {
-				leftRecipes := findRecipeWithAlgorithm(c.Left)
-				if len(leftRecipes) == 0 {
-					continue
+	products:
+		for _, l := range left.nodes {
+			for _, r := range right.nodes {
+				if ctx.Err() != nil {
+					return
 				}
-				rightRecipes := findRecipeWithAlgorithm(c.Right)
-				if len(rightRecipes) == 0 {
+				task.examined++
+				node := &Node{Element: target, Left: l, Right: r}
+				if !cons.Satisfies(node) {
 					continue
 				}
-
-				for _, left := range leftRecipes {
-					for _, right := range rightRecipes {
-						node := &Node{Element: elem, Left: left, Right: right}
-						if elem == target {
-							signature := serializeTree(node)
-							if _, exists := seen.LoadOrStore(signature, true); !exists {
-								mu.Lock()
-								if len(results) < maxCount {
-									results = append(results, node)
-								}
-								mu.Unlock()
-								if len(results) >= maxCount {
-									cancel()
-									return localResults
-								}
-							}
-						}
-						localResults = append(localResults, node)
-						atomic.AddInt32(&MultiVisitedCount, 1)
-					}
+				task.nodes = append(task.nodes, node)
+				if len(task.nodes) == perCombo {
+					break products
 				}
 			}
 		}
-		if len(localResults) > 0 {
-			sort.Slice(localResults, func(i, j int) bool {
-				return treeDepth(localResults[i]) < treeDepth(localResults[j])
-			})
-			recipeCache.Store(elem, localResults)
-		}
-		return localResults
+
+		task.done = true
+		mu.Lock()
+		defer mu.Unlock()
+		tasks[i] = task
+		merge()
 	}
 
 	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		findRecipe(ctx, target, nil)
-	}()
+	for i, c := range combos {
+		if ctx.Err() != nil {
+			break
+		}
+		pool.Go(&wg, func() { expand(i, c) })
+	}
 	wg.Wait()
+	if err := parent.Err(); err != nil {
+		return nil, 0, err
+	}
 
-	if len(results) > 0 {
-		sort.Slice(results, func(i, j int) bool {
-			return treeDepth(results[i]) < treeDepth(results[j])
-		})
+	visited := 0
+	counted := make(map[*recipeEntry]bool)
+	for _, task := range tasks[:merged] {
+		visited += task.examined
+		for _, entry := range task.ingredients {
+			if !counted[entry] {
+				counted[entry] = true
+				visited += entry.visited
+			}
+		}
+	}
+
+	if len(results) == 0 && cons.hasTreeLimits() {
+		node, err := FindConstrainedRecipe(target, cons)
+		if err != nil {
+			return nil, visited, err
+		}
+		if node != nil {
+			results = append(results, node)
+		}
 	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return treeDepth(results[i]) < treeDepth(results[j])
+	})
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		metrics.multiTimeouts.Add(1)
+		logger.Warn("multiple recipe search timed out", "target", target, "algorithm", algorithm, "recipes", len(results))
+	} else {
+		recipeCache.Put(key, cachedRecipes{nodes: results, visited: visited})
+	}
+	return results, visited, nil
+}
+
+func FindMultipleRecipesBFS(target string, cons *Constraints) []*Node {
+	results, visited := multipleRecipesBFS(context.Background(), target, cons, 0, nil)
+	BFSVisitedCount = visited
 	return results
 }
 
-func FindMultipleRecipesBFS(target string) []*Node {
-	fmt.Printf("\n=== Starting Multiple BFS search for: %s ===\n", target)
-	
+// multipleRecipesBFS is FindMultipleRecipesBFS with its visited count
+// returned instead of stored globally, so calls can run concurrently. It
+// stops when ctx is done and keeps at most limit recipes per element when
+// limit > 0. Progress is reported to observe.
+func multipleRecipesBFS(ctx context.Context, target string, cons *Constraints, limit int, observe SearchObserver) ([]*Node, int) {
+	log := newSearchLog(ctx, "bfs", "multiple", target)
+	log.debug("search started")
+
+	if !cons.AllowsElement(target) {
+		log.debug("target excluded")
+		return nil, 0
+	}
+
 	if isBasic(target) {
-		fmt.Printf("Found basic element: %s\n", target)
-		BFSVisitedCount = 1
-		return []*Node{{Element: target}}
+		log.debug("target is basic")
+		return []*Node{{Element: target}}, 1
 	}
 
 	if _, exists := combinations[target]; !exists {
-		fmt.Printf("Element %s not found in combinations\n", target)
-		BFSVisitedCount = 0
-		return nil
+		log.debug("target not in dataset")
+		return nil, 0
 	}
 
-	fmt.Printf("Found %d combinations for %s\n", len(combinations[target]), target)
 	visited := make(map[string]bool)
 	recipeMap := make(map[string][]*Node)
 	queue := []string{target}
-	BFSVisitedCount = 0
+	var order []string
+	visitedCount := 0
 
-	fmt.Println("\nFirst pass: Collecting combinations...")
 	for len(queue) > 0 {
 		current := queue[0]
 		queue = queue[1:]
@@ -600,21 +1010,17 @@ func FindMultipleRecipesBFS(target string) []*Node {
 			continue
 		}
 		visited[current] = true
-		BFSVisitedCount++
-		fmt.Printf("Visiting: %s (visited count: %d)\n", current, BFSVisitedCount)
+		order = append(order, current)
+		visitedCount++
+		observe.emit(SearchEvent{Type: EventVisit, Search: target, Element: current, Visited: visitedCount, Frontier: len(queue)})
 
 		if isBasic(current) {
-			fmt.Printf("Found basic element: %s\n", current)
 			recipeMap[current] = []*Node{{Element: current}}
 			continue
 		}
 
-		validCombos := []Combination{}
-		for _, comb := range combinations[current] {
-			if tierMap[comb.Left] < tierMap[current] && tierMap[comb.Right] < tierMap[current] {
-				validCombos = append(validCombos, comb)
-			}
-		}
+		observe.skipped(target, current, cons)
+		validCombos := allowedCombos(current, cons)
 
 		sort.SliceStable(validCombos, func(i, j int) bool {
 			iDiff := (tierMap[current] - tierMap[validCombos[i].Left]) + (tierMap[current] - tierMap[validCombos[i].Right])
@@ -623,38 +1029,36 @@ func FindMultipleRecipesBFS(target string) []*Node {
 		})
 
 		for _, comb := range validCombos {
-			fmt.Printf("  Checking combination: %s (tier %d) + %s (tier %d) = %s (tier %d)\n", 
-				comb.Left, tierMap[comb.Left], comb.Right, tierMap[comb.Right], comb.Root, comb.Tier)
-			
 			if !visited[comb.Left] {
 				queue = append(queue, comb.Left)
-				fmt.Printf("    Added to queue: %s\n", comb.Left)
+				observe.emit(SearchEvent{Type: EventPush, Search: target, Element: comb.Left, Parent: current})
 			}
 			if !visited[comb.Right] {
 				queue = append(queue, comb.Right)
-				fmt.Printf("    Added to queue: %s\n", comb.Right)
+				observe.emit(SearchEvent{Type: EventPush, Search: target, Element: comb.Right, Parent: current})
 			}
 		}
 	}
 
-	fmt.Println("\nSecond pass: Building recipes...")
 	changed := true
-	for changed {
+	for changed && ctx.Err() == nil {
 		changed = false
-		for elem := range visited {
+		for _, elem := range order {
 			if len(recipeMap[elem]) > 0 {
 				continue
 			}
 
-			for _, comb := range combinations[elem] {
-				if tierMap[comb.Left] < tierMap[elem] && tierMap[comb.Right] < tierMap[elem] {
+			for _, comb := range cons.ordered(combinations[elem]) {
+				if cons.Allows(comb) {
 					leftRecipes := recipeMap[comb.Left]
 					rightRecipes := recipeMap[comb.Right]
 					if len(leftRecipes) > 0 && len(rightRecipes) > 0 {
 						for _, left := range leftRecipes {
 							for _, right := range rightRecipes {
-								fmt.Printf("Found recipe for %s: %s + %s\n", 
-									elem, comb.Left, comb.Right)
+								if limit > 0 && len(recipeMap[elem]) >= limit {
+									break
+								}
+								observe.emit(SearchEvent{Type: EventRecipe, Search: target, Element: elem, Left: comb.Left, Right: comb.Right})
 								recipeMap[elem] = append(recipeMap[elem], &Node{
 									Element: elem,
 									Left:    left,
@@ -670,230 +1074,22 @@ func FindMultipleRecipesBFS(target string) []*Node {
 	}
 
 	results := recipeMap[target]
-	if len(results) > 0 {
-		fmt.Printf("\nSuccessfully found %d recipes for %s\n", len(results), target)
-	} else {
-		fmt.Printf("\nNo valid recipe found for %s\n", target)
-	}
-	return results
-}
-
-func exploreRecipe(target string, visited map[string]bool, counter *int32, algorithm string) *Node {
-	if isBasic(target) {
-		atomic.AddInt32(counter, 1)
-		return &Node{Element: target}
-	}
-	if visited[target] {
-		return nil
-	}
-	visited[target] = true
-	atomic.AddInt32(counter, 1)
-
-	var candidates []*Node
-	validCombos := []Combination{}
-
-	targetTier := tierMap[target]
-
-	for _, comb := range combinations[target] {
-		if tierMap[comb.Left] < targetTier && tierMap[comb.Right] < targetTier {
-			leftTierDiff := targetTier - tierMap[comb.Left]
-			rightTierDiff := targetTier - tierMap[comb.Right]
-			avgTierDiff := (leftTierDiff + rightTierDiff) / 2
-			comb.Tier = avgTierDiff
-			validCombos = append(validCombos, comb)
-		}
-	}
-
-	sort.SliceStable(validCombos, func(i, j int) bool {
-		if validCombos[i].Tier != validCombos[j].Tier {
-			return validCombos[i].Tier < validCombos[j].Tier
-		}
-		return len(validCombos[i].Left)+len(validCombos[i].Right) < len(validCombos[j].Left)+len(validCombos[j].Right)
-	})
-
-	for _, comb := range validCombos {
-		variations := []struct {
-			left  string
-			right string
-		}{
-			{comb.Left, comb.Right},
-			{comb.Right, comb.Left},
-		}
-
-		for _, v := range variations {
-			leftVisited := copyVisitedMap(visited)
-			var left *Node
-			
-			switch algorithm {
-			case "bfs":
-				left = FindRecipeBFS(v.left)
-			case "dfs":
-				left = FindRecipeDFS(v.left, leftVisited)
-			case "bidirectional":
-				left = FindRecipeBidirectional(v.left)
-			default:
-				left = exploreRecipe(v.left, leftVisited, counter, algorithm)
-			}
-
-			if left == nil {
-				continue
-			}
-
-			rightVisited := copyVisitedMap(visited)
-			var right *Node
-			
-			switch algorithm {
-			case "bfs":
-				right = FindRecipeBFS(v.right)
-			case "dfs":
-				right = FindRecipeDFS(v.right, rightVisited)
-			case "bidirectional":
-				right = FindRecipeBidirectional(v.right)
-			default:
-				right = exploreRecipe(v.right, rightVisited, counter, algorithm)
-			}
-
-			if right == nil {
-				continue
-			}
-
-			candidates = append(candidates,
-				&Node{Element: target, Left: left, Right: right},
-				&Node{Element: target, Left: right, Right: left},
-			)
-		}
-	}
-
-	if len(candidates) == 0 {
-		return nil
-	}
-
-	rand.Seed(time.Now().UnixNano())
-	return candidates[rand.Intn(len(candidates))]
+	log.debug("search finished", slog.Int("recipes", len(results)), slog.Int("visited", visitedCount))
+	return results, visitedCount
 }
 
+// getSortedBasicElements returns the basic elements the dataset mentions,
+// whether as a result or only as an ingredient, in name order.
 func getSortedBasicElements() []string {
 	basics := []string{}
-	for elem := range tierMap {
-		if isBasic(elem) {
+	for _, elem := range []string{"Air", "Earth", "Fire", "Time", "Water"} {
+		if _, listed := tierMap[elem]; listed || len(reverseMap[elem]) > 0 {
 			basics = append(basics, elem)
 		}
 	}
-	sort.Strings(basics)
 	return basics
 }
 
-func FindRecipeBidirectional(target string) *Node {
-	fmt.Printf("\n=== Starting Bidirectional Search ===\n")
-	fmt.Printf("Target: %s (Tier: %d)\n", target, tierMap[target])
-	basics := getSortedBasicElements()
-	fmt.Printf("Start Elements: %v\n", basics)
-
-	if isBasic(target) {
-		fmt.Printf("Target is a basic element, returning direct node\n")
-		return &Node{Element: target}
-	}
-	if _, exists := combinations[target]; !exists {
-		fmt.Printf("Target element not found in combinations\n")
-		return nil
-	}
-
-	forwardVisited := make(map[string]*Node)
-	forwardQueue := []string{}
-	for _, b := range basics {
-		forwardVisited[b] = &Node{Element: b}
-		forwardQueue = append(forwardQueue, b)
-	}
-	
-	backwardVisited := make(map[string]bool)
-	backwardQueue := []string{target}
-	backwardVisited[target] = true
-	
-	BidirectionalVisitedCount = len(basics) + 1
-	fmt.Printf("Initialized bidirectional search\n")
-
-	for len(forwardQueue) > 0 && len(backwardQueue) > 0 {
-		currentForward := forwardQueue[0]
-		forwardQueue = forwardQueue[1:]
-		fmt.Printf("\nForward exploring from: %s (Tier: %d)\n", currentForward, tierMap[currentForward])
-
-		if backwardVisited[currentForward] {
-			fmt.Printf("Found intersection at: %s\n", currentForward)
-			forwardPath := forwardVisited[currentForward]
-			
-			for _, comb := range combinations[target] {
-				if comb.Left == currentForward || comb.Right == currentForward {
-					var otherElement string
-					if comb.Left == currentForward {
-						otherElement = comb.Right
-					} else {
-						otherElement = comb.Left
-					}
-					
-					if otherNode, exists := forwardVisited[otherElement]; exists {
-						return &Node{
-							Element: target,
-							Left:   forwardPath,
-							Right:  otherNode,
-						}
-					}
-				}
-			}
-		}
-
-		for _, comb := range combinations {
-			for _, c := range comb {
-				if (c.Left == currentForward || c.Right == currentForward) &&
-					forwardVisited[c.Left] != nil && forwardVisited[c.Right] != nil &&
-					tierMap[c.Left] < tierMap[c.Root] && tierMap[c.Right] < tierMap[c.Root] {
-					
-					if _, exists := forwardVisited[c.Root]; !exists {
-						fmt.Printf("  Forward found: %s + %s = %s\n", c.Left, c.Right, c.Root)
-						forwardVisited[c.Root] = &Node{
-							Element: c.Root,
-							Left:    forwardVisited[c.Left],
-							Right:   forwardVisited[c.Right],
-						}
-						forwardQueue = append(forwardQueue, c.Root)
-						BidirectionalVisitedCount++
-					}
-				}
-			}
-		}
-
-		currentBackward := backwardQueue[0]
-		backwardQueue = backwardQueue[1:]
-		fmt.Printf("\nBackward exploring from: %s (Tier: %d)\n", currentBackward, tierMap[currentBackward])
-
-		for _, comb := range combinations {
-			for _, c := range comb {
-				if c.Root == currentBackward {
-					if !backwardVisited[c.Left] {
-						backwardVisited[c.Left] = true
-						backwardQueue = append(backwardQueue, c.Left)
-						BidirectionalVisitedCount++
-					}
-					if !backwardVisited[c.Right] {
-						backwardVisited[c.Right] = true
-						backwardQueue = append(backwardQueue, c.Right)
-						BidirectionalVisitedCount++
-					}
-				}
-			}
-		}
-	}
-
-	return nil
-}
-
-func copyVisitedMap(original map[string]bool) map[string]bool {
-	copy := make(map[string]bool)
-	for k, v := range original {
-		copy[k] = v
-	}
-	return copy
-}
-
 func treeDepth(node *Node) int {
 	if node == nil {
 		return 0
@@ -949,9 +1145,35 @@ func GetBidirectionalVisited() int {
 	return BidirectionalVisitedCount
 }
 
+// AllowedOrigins lists the origins browsers may call the API from, set from
+// the comma-separated ALLOWED_ORIGINS environment variable. "*" allows every
+// origin. CORS does not cover WebSockets, so upgradeWebsocket checks the
+// same list itself.
+var AllowedOrigins = []string{"*"}
+
+// originAllowed reports whether AllowedOrigins lets a browser on origin call
+// the API.
+func originAllowed(origin string) bool {
+	for _, allowed := range AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// enableCORS allows the origins in AllowedOrigins to call next from a
+// browser.
 func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if slices.Contains(AllowedOrigins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); origin != "" && originAllowed(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
@@ -964,111 +1186,285 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func handleSearch(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// findSingleRecipe runs the single-recipe search for mode and returns the
+// recipe along with the number of visited nodes. It fails if mode is not a
+// known algorithm or the constraints make the search give up. Answers come
+// from the solution index or recipeCache when they have one, and from
+// searchSingleRecipe otherwise.
+func findSingleRecipe(ctx context.Context, element, mode string, cons *Constraints) (result *Node, visited int, err error) {
+	if solution, indexed := lookupIndex(element, mode, cons); indexed {
+		return solution.node, solution.visited, nil
 	}
 
-	element := r.URL.Query().Get("element")
-	if element == "" {
-		http.Error(w, "Element parameter is required", http.StatusBadRequest)
-		return
+	key := recipeCacheKey("single", mode, element, 1, cons)
+	if cached, hit := recipeCache.Get(key); hit {
+		return cached.nodes[0], cached.visited, nil
+	}
+
+	result, visited, err = searchSingleRecipe(ctx, element, mode, cons, nil)
+	if err == nil {
+		recipeCache.Put(key, cachedRecipes{nodes: []*Node{result}, visited: visited})
 	}
+	return result, visited, err
+}
 
-	mode := r.URL.Query().Get("mode")
-	recipeMode := r.URL.Query().Get("recipe_mode")
-	fmt.Printf("\n=== Search Request ===\n")
-	fmt.Printf("Element: %s (Tier: %d)\n", element, tierMap[element])
-	fmt.Printf("Mode: %s\n", mode)
-	fmt.Printf("Recipe Mode: %s\n", recipeMode)
+// singleRecipeModes are the algorithms searchSingleRecipe knows.
+var singleRecipeModes = []string{"bfs", "dfs", "bidirectional"}
 
-	var result *Node
-	var results []*Node
-	var visited int
-	var executionTime time.Duration
-	var response struct {
-		Found         bool     `json:"found"`
-		Steps         int      `json:"steps"`
-		Paths         [][]Step `json:"paths"`
-		Target        struct {
-			Element string `json:"element"`
-			Tier    int    `json:"tier"`
-		} `json:"target"`
-		ExecutionTime float64 `json:"executionTime"`
+var errInvalidMode = errors.New("Invalid mode")
+
+// searchSingleRecipe is findSingleRecipe without the index and cache,
+// reporting the search's progress to observe. It fails with ctx's error if
+// ctx is done before the search finishes.
+func searchSingleRecipe(ctx context.Context, element, mode string, cons *Constraints, observe SearchObserver) (result *Node, visited int, err error) {
+	switch mode {
+	case "bfs":
+		result, visited = findRecipeBFS(ctx, element, cons, observe)
+	case "dfs":
+		result, visited = findRecipeDFS(ctx, element, nil, cons, observe)
+	case "bidirectional":
+		result, visited = findRecipeBidirectional(ctx, element, cons, observe)
+	default:
+		return nil, 0, errInvalidMode
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, visited, err
 	}
+	result, err = enforceConstraints(element, result, cons)
+	return result, visited, err
+}
 
-	response.Target.Element = element
-	response.Target.Tier = tierMap[element]
+// SearchResponse is the answer of /search, and the payload of the final
+// event of /search/stream. Logs holds the log records of a debug=true
+// request, and LogsDropped counts those left out past maxCapturedLogs.
+type SearchResponse struct {
+	Found     bool            `json:"found"`
+	Steps     int             `json:"steps"`
+	Paths     [][]Step        `json:"paths"`
+	RecipeIDs []string        `json:"recipeIds,omitempty"`
+	Orders    []CraftingOrder `json:"orders,omitempty"`
+	Target    struct {
+		Element string `json:"element"`
+		Tier    int    `json:"tier"`
+	} `json:"target"`
+	ExecutionTime *float64   `json:"executionTime,omitempty"`
+	Logs          []LogEntry `json:"logs,omitempty"`
+	LogsDropped   int        `json:"logsDropped,omitempty"`
+}
 
-	startTime := time.Now()
+// maxRecipesLimit caps max_recipes. Multiple-recipe searches keep up to
+// that many recipes per ingredient, so larger values only cost memory.
+const maxRecipesLimit = 1000
+
+// searchRequest holds the query parameters shared by /search and
+// /search/stream, and the logger and context of the request. The search
+// stops once the context is done. With debug set, /search returns the
+// request's logs at every level along with the answer.
+type searchRequest struct {
+	element    string
+	mode       string
+	recipeMode string
+	maxRecipes int
+	diverse    bool
+	order      string
+	timing     bool
+	ids        bool
+	debug      bool
+	cons       *Constraints
+	log        *slog.Logger
+	ctx        context.Context
+}
 
-	if recipeMode == "single" {
-		switch mode {
-		case "bfs":
-			result = FindRecipeBFS(element)
-			visited = GetBFSVisited()
-			if result != nil {
-				path := convertRecipeToPath(result)
-				response.Found = true
-				response.Steps = visited
-				response.Paths = [][]Step{path}
-			}
-		case "dfs":
-			result = FindRecipeDFS(element, nil)
-			visited = GetDFSVisited()
-			if result != nil {
-				path := convertRecipeToPath(result)
-				response.Found = true
-				response.Steps = visited
-				response.Paths = [][]Step{path}
-			}
-		case "bidirectional":
-			result = FindRecipeBidirectional(element)
-			visited = BidirectionalVisitedCount
-			if result != nil {
-				path := convertRecipeToPath(result)
-				response.Found = true
-				response.Steps = visited
-				response.Paths = [][]Step{path}
-			}
+func parseSearchRequest(query url.Values) (*searchRequest, error) {
+	req := &searchRequest{
+		element:    query.Get("element"),
+		mode:       query.Get("mode"),
+		recipeMode: query.Get("recipe_mode"),
+		maxRecipes: 10,
+		diverse:    query.Get("diverse") == "true",
+		order:      query.Get("order"),
+		timing:     query.Get("timing") != "false",
+		ids:        query.Get("ids") != "false",
+		debug:      query.Get("debug") == "true",
+		log:        logger,
+		ctx:        context.Background(),
+	}
+	if req.element == "" {
+		return nil, errors.New("Element parameter is required")
+	}
+
+	cons, err := ParseConstraints(query)
+	if err != nil {
+		return nil, err
+	}
+	req.cons = cons
+
+	if err := parseSeed(query, req.cons); err != nil {
+		return nil, err
+	}
+
+	if req.order != "" && !orderKinds[req.order] {
+		return nil, errors.New("Invalid order")
+	}
+
+	switch req.recipeMode {
+	case "single":
+		switch req.mode {
+		case "bfs", "dfs", "bidirectional":
 		default:
-			fmt.Printf("Invalid mode: %s\n", mode)
-			http.Error(w, "Invalid mode", http.StatusBadRequest)
-			return
+			return nil, errInvalidMode
 		}
-	} else if recipeMode == "multiple" {
-		maxRecipes := 10
-		if maxRecipesStr := r.URL.Query().Get("max_recipes"); maxRecipesStr != "" {
+	case "multiple":
+		if maxRecipesStr := query.Get("max_recipes"); maxRecipesStr != "" {
 			if parsed, err := strconv.Atoi(maxRecipesStr); err == nil && parsed > 0 {
-				maxRecipes = parsed
+				req.maxRecipes = min(parsed, maxRecipesLimit)
 			}
 		}
-		fmt.Printf("Requested max recipes: %d\n", maxRecipes)
-		results = FindMultipleRecipes(element, maxRecipes, mode)
-		visited = GetMultiVisited()
-		if len(results) > 0 {
-			paths := make([][]Step, 0, len(results))
-			for _, result := range results {
-				path := convertRecipeToPath(result)
-				paths = append(paths, path)
-			}
-			response.Found = true
-			response.Steps = visited
-			response.Paths = paths
+	default:
+		return nil, errors.New("Invalid recipe mode")
+	}
+	return req, nil
+}
+
+// parseSeed sets the Seed of cons from the seed parameter, if there is one.
+func parseSeed(query url.Values, cons *Constraints) error {
+	if seedStr := query.Get("seed"); seedStr != "" {
+		seed, err := strconv.ParseInt(seedStr, 10, 64)
+		if err != nil {
+			return errors.New("Invalid seed")
+		}
+		cons.Seed = seed
+	}
+	return nil
+}
+
+// runSearch answers req. With an observer, the search runs live instead of
+// from the solution index or recipe cache, so that every step is reported.
+// It fails with ErrSearchBudget when the constrained search gives up, and
+// with the context's error when req.ctx is done before the search finishes.
+// The searches log to req.log.
+func runSearch(req *searchRequest, observe SearchObserver) (*SearchResponse, error) {
+	ctx := context.WithValue(req.ctx, requestLoggerKey{}, req.log)
+	req.log.Debug("search started",
+		"element", req.element,
+		"tier", tierMap[req.element],
+		"mode", req.mode,
+		"recipe_mode", req.recipeMode)
+
+	var results []*Node
+	var visited int
+	var err error
+	response := &SearchResponse{}
+	response.Target.Element = req.element
+	response.Target.Tier = tierMap[req.element]
+
+	startTime := time.Now()
+
+	if req.recipeMode == "single" {
+		var result *Node
+		if observe != nil {
+			result, visited, err = searchSingleRecipe(ctx, req.element, req.mode, req.cons, observe)
+		} else {
+			result, visited, err = findSingleRecipe(ctx, req.element, req.mode, req.cons)
+		}
+		if result != nil {
+			results = []*Node{result}
 		}
 	} else {
-		http.Error(w, "Invalid recipe mode", http.StatusBadRequest)
+		req.log.Debug("multiple recipe search", "max_recipes", req.maxRecipes, "diverse", req.diverse)
+		if req.diverse {
+			results, visited, err = findDiverseRecipes(ctx, req.element, req.maxRecipes, req.mode, req.cons, observe)
+		} else {
+			results, visited, err = findMultipleRecipes(ctx, req.element, req.maxRecipes, req.maxRecipes, req.mode, req.cons, observe)
+		}
+	}
+	if req.ctx.Err() != nil {
+		req.log.Debug("search cancelled", "element", req.element, "error", req.ctx.Err())
+		metrics.countSearch(req.mode, req.recipeMode, outcomeCancelled)
+		return nil, req.ctx.Err()
+	}
+	if err != nil {
+		req.log.Warn("search gave up", "element", req.element, "constraints", req.cons.key(), "error", err)
+		metrics.countSearch(req.mode, req.recipeMode, outcomeGaveUp)
+		return nil, err
+	}
+
+	response.setResults(req, results, visited)
+	metrics.observeSearch(req.mode, req.recipeMode, time.Since(startTime), visited, response.Found)
+	req.log.Debug("search finished", "element", req.element, "recipes", len(results), "visited", visited)
+
+	// executionTime is the only part of the response that differs between
+	// identical requests. timing=false leaves it out for callers that need
+	// byte-identical output, such as snapshot tests.
+	if req.timing {
+		milliseconds := float64(time.Since(startTime).Microseconds()) / 1000.0
+		response.ExecutionTime = &milliseconds
+	}
+	return response, nil
+}
+
+// setResults fills in the recipes found for req and the number of nodes the
+// search visited. Nothing is set when results is empty. The recipes are
+// registered and their IDs returned unless req opts out with ids=false.
+func (response *SearchResponse) setResults(req *searchRequest, results []*Node, visited int) {
+	if len(results) == 0 {
+		return
+	}
+	paths := make([][]Step, 0, len(results))
+	var ids []string
+	for _, result := range results {
+		path := convertRecipeToPath(result)
+		paths = append(paths, path)
+		if req.ids {
+			ids = append(ids, registry.Register(result))
+		}
+		if req.order != "" {
+			response.Orders = append(response.Orders, LinearizeRecipe(result, req.order))
+		}
+	}
+	response.Found = true
+	response.Steps = visited
+	response.Paths = paths
+	response.RecipeIDs = ids
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	executionTime = time.Since(startTime)
-	response.ExecutionTime = float64(executionTime.Microseconds()) / 1000.0
+	query := r.URL.Query()
+	req, err := parseSearchRequest(query)
+	if err != nil {
+		metrics.countSearch(query.Get("mode"), query.Get("recipe_mode"), outcomeBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.log = requestLogger(r.Context())
+	req.ctx = r.Context()
+
+	var response *SearchResponse
+	var capture *logCapture
+	if req.debug {
+		capture = newLogCapture(req.log.Handler())
+		req.log = slog.New(capture)
+		response, err = runSearch(req, logEvents(req.log))
+	} else {
+		response, err = runSearch(req, nil)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if capture != nil {
+		response.Logs, response.LogsDropped = capture.Entries()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		fmt.Printf("Error encoding response: %v\n", err)
+		req.log.Error("encoding response", "error", err)
 		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 		return
 	}
@@ -1124,21 +1520,66 @@ func handleMode(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	fmt.Println("Starting server...")
-	
-	err := LoadCombinations("combinations.json")
-	if err != nil {
-		fmt.Printf("Error loading combinations: %v\n", err)
-		panic(err)
+	setupLogging()
+
+	if err := LoadCombinations("combinations.json"); err != nil {
+		logger.Error("loading combinations", "file", "combinations.json", "error", err)
+		os.Exit(1)
+	}
+
+	if err := LoadWhitelist("whitelist.json"); err != nil {
+		logger.Error("loading whitelist", "file", "whitelist.json", "error", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCLI(os.Args[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := LoadIndex("index.json"); err != nil {
+		logger.Warn("ignoring solution index", "error", err)
+	}
+	if err := LoadRecipeRegistry("recipes.jsonl"); err != nil {
+		logger.Error("loading recipe registry", "file", "recipes.jsonl", "error", err)
+		os.Exit(1)
+	}
+
+	if workers, err := strconv.Atoi(os.Getenv("MULTI_WORKERS")); err == nil {
+		MultiWorkers = workers
+	}
+	if megabytes, err := strconv.Atoi(os.Getenv("RECIPE_CACHE_MB")); err == nil && megabytes >= 0 {
+		RecipeCacheBytes = int64(megabytes) << 20
+	}
+	if origins := splitList(os.Getenv("ALLOWED_ORIGINS")); len(origins) > 0 {
+		AllowedOrigins = origins
 	}
 
 	http.HandleFunc("/search", enableCORS(handleSearch))
+	http.HandleFunc("/search/stream", enableCORS(handleSearchStream))
+	http.HandleFunc("/search/trace", enableCORS(handleSearchTrace))
+	http.HandleFunc("/search/session", enableCORS(handleSearchSession))
+	http.HandleFunc("/compare", enableCORS(handleCompare))
 	http.HandleFunc("/mode", enableCORS(handleMode))
+	http.HandleFunc("/cache/stats", enableCORS(handleCacheStats))
+	http.HandleFunc("/metrics", enableCORS(handleMetrics))
+	http.HandleFunc("/elements/{name}/uses", enableCORS(handleUses))
+	http.HandleFunc("/elements/{name}/descendants", enableCORS(handleDescendants))
+	http.HandleFunc("/elements/{name}/mandatory", enableCORS(handleMandatory))
+	http.HandleFunc("/elements/{name}/stats", enableCORS(handleElementStats))
+	http.HandleFunc("/whatif", enableCORS(handleWhatIf))
+	http.HandleFunc("/plan", enableCORS(handlePlan))
+	http.HandleFunc("/recipes/{id}", enableCORS(handleRecipe))
+	http.HandleFunc("/validate", enableCORS(handleValidate))
+	http.HandleFunc("/analysis/cycles", enableCORS(handleCycles))
 
 	port := ":5000"
-	fmt.Printf("Server starting on port %s...\n", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		fmt.Printf("Error starting server: %v\n", err)
-		panic(err)
+	logger.Info("server starting", "port", port, "elements", len(tierMap))
+	if err := http.ListenAndServe(port, logRequests(http.DefaultServeMux)); err != nil {
+		logger.Error("starting server", "error", err)
+		os.Exit(1)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,1991 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var loadOnce sync.Once
+
+// loadDataset loads combinations.json once per test binary.
+func loadDataset(tb testing.TB) {
+	tb.Helper()
+	loadOnce.Do(func() {
+		if err := LoadCombinations("combinations.json"); err != nil {
+			tb.Fatal(err)
+		}
+		if err := LoadWhitelist("whitelist.json"); err != nil {
+			tb.Fatal(err)
+		}
+	})
+}
+
+// BenchmarkFindMultipleRecipes compares a single worker with the default
+// GOMAXPROCS-sized pool, with the recipe cache disabled so every iteration
+// does the full search. The speedup shows up on multi-core machines, e.g.
+// go test -bench FindMultipleRecipes -cpu 1,4,8.
+func BenchmarkFindMultipleRecipes(b *testing.B) {
+	loadDataset(b)
+	defer func(workers int) { MultiWorkers = workers }(MultiWorkers)
+	defer func(capacity int64) { RecipeCacheBytes = capacity }(RecipeCacheBytes)
+
+	workerCounts := []int{1}
+	if procs := runtime.GOMAXPROCS(0); procs > 1 {
+		workerCounts = append(workerCounts, procs)
+	}
+
+	for _, workers := range workerCounts {
+		for _, target := range []string{"Human", "Golem", "Beach"} {
+			b.Run(fmt.Sprintf("%s/workers=%d", target, workers), func(b *testing.B) {
+				MultiWorkers = workers
+				RecipeCacheBytes = 0
+				recipeCache.Reset()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if len(FindMultipleRecipes(target, 50, "bfs", nil)) == 0 {
+						b.Fatalf("no recipes for %s", target)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestSearchGivesUp checks that a constrained search that runs out of
+// budget is reported as an error rather than as "no recipe".
+func TestSearchGivesUp(t *testing.T) {
+	loadDataset(t)
+	query := "element=Lava&mode=bfs&recipe_mode=single&policy=none&include=Dragon,Phoenix,Unicorn,Vampire,Zombie,Yeti"
+	r := httptest.NewRequest(http.MethodGet, "/search?"+query, nil)
+	w := httptest.NewRecorder()
+	handleSearch(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), ErrSearchBudget.Error()) {
+		t.Errorf("body %q does not report the budget", w.Body.String())
+	}
+}
+
+// TestSearchDeterministic runs every search twice, with and without a seed,
+// and checks that identical requests get byte-identical answers.
+func TestSearchDeterministic(t *testing.T) {
+	loadDataset(t)
+	defer func(capacity int64) { RecipeCacheBytes = capacity }(RecipeCacheBytes)
+	RecipeCacheBytes = 0
+	recipeCache.Reset()
+
+	search := func(query string) string {
+		r := httptest.NewRequest(http.MethodGet, "/search?"+query, nil)
+		w := httptest.NewRecorder()
+		handleSearch(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	for _, seed := range []string{"", "&seed=7", "&seed=-42"} {
+		for _, mode := range []string{"recipe_mode=single&mode=bfs", "recipe_mode=single&mode=dfs",
+			"recipe_mode=single&mode=bidirectional", "recipe_mode=multiple&mode=bfs&max_recipes=20",
+			"recipe_mode=multiple&mode=dfs&max_recipes=20"} {
+			query := "element=Golem&timing=false&" + mode + seed
+			if first, second := search(query), search(query); first != second {
+				t.Errorf("%s: answers differ:\n%s\n%s", query, first, second)
+			}
+		}
+	}
+}
+
+func TestSearchInvalidSeed(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/search?element=Golem&mode=bfs&recipe_mode=single&seed=x", nil)
+	w := httptest.NewRecorder()
+	handleSearch(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestRecipeCacheDisabled checks that a zero budget stops the cache from
+// serving entries stored before, and that Reset empties it.
+func TestRecipeCacheDisabled(t *testing.T) {
+	defer func(capacity int64) { RecipeCacheBytes = capacity }(RecipeCacheBytes)
+	RecipeCacheBytes = 1 << 20
+	cache := &recipeLRU{items: make(map[string]*list.Element), order: list.New()}
+	cache.Put("key", cachedRecipes{nodes: []*Node{{Element: "Fire"}}, visited: 1})
+
+	RecipeCacheBytes = 0
+	if _, ok := cache.Get("key"); ok {
+		t.Error("disabled cache served an entry")
+	}
+
+	RecipeCacheBytes = 1 << 20
+	if _, ok := cache.Get("key"); !ok {
+		t.Error("entry lost while the cache was disabled")
+	}
+	cache.Reset()
+	if _, ok := cache.Get("key"); ok {
+		t.Error("entry survived Reset")
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("after Reset: %d entries, %d bytes", stats.Entries, stats.Bytes)
+	}
+}
+
+// TestIndexStamp checks that a loaded index serves the same element stats as
+// the live computation, and that an index built by another search version is
+// rejected.
+func TestIndexStamp(t *testing.T) {
+	loadDataset(t)
+	defer LoadIndex("")
+
+	index := BuildIndex()
+	filename := filepath.Join(t.TempDir(), "index.json")
+	if err := WriteIndex(index, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	live := elementStats("Golem")
+	if err := LoadIndex(filename); err != nil {
+		t.Fatal(err)
+	}
+	indexed := elementStats("Golem")
+	if !indexed.Indexed || indexed.MinDepth != live.MinDepth || indexed.RecipeCount.Cmp(live.RecipeCount) != 0 {
+		t.Errorf("indexed stats %+v, live %+v", indexed, live)
+	}
+
+	index.Search = searchVersion + 1
+	if err := WriteIndex(index, filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadIndex(filename); err == nil {
+		t.Error("loaded an index built by another search version")
+	}
+	if solutionIndex != nil || indexEntries != nil {
+		t.Error("rejected index left loaded")
+	}
+}
+
+// TestElementStatsCached checks that without an index the element stats are
+// computed once per dataset and then served from recipeCache.
+func TestElementStatsCached(t *testing.T) {
+	loadDataset(t)
+	defer func(hash string) { datasetHash = hash }(datasetHash)
+	recipeCache.Reset()
+
+	first := elementStats("Golem")
+	before := recipeCache.Stats()
+	second := elementStats("Golem")
+	after := recipeCache.Stats()
+	if after.Hits != before.Hits+1 {
+		t.Errorf("second call: %d hits, want %d", after.Hits, before.Hits+1)
+	}
+	if second.MinDepth != first.MinDepth || second.RecipeCount.Cmp(first.RecipeCount) != 0 {
+		t.Errorf("cached stats %+v, computed %+v", second, first)
+	}
+
+	datasetHash += "-other"
+	elementStats("Golem")
+	if stats := recipeCache.Stats(); stats.Misses != after.Misses+1 {
+		t.Errorf("after a dataset change: %d misses, want %d", stats.Misses, after.Misses+1)
+	}
+}
+
+// TestRecipeIDs checks that /search returns recipe IDs by default, that they
+// resolve through /recipes/{id}, and that ids=false opts out of registering.
+func TestRecipeIDs(t *testing.T) {
+	loadDataset(t)
+	if err := LoadRecipeRegistry(filepath.Join(t.TempDir(), "recipes.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Close()
+
+	search := func(query string) SearchResponse {
+		w := httptest.NewRecorder()
+		handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+		var response SearchResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return response
+	}
+
+	query := "element=Golem&mode=bfs&recipe_mode=multiple&max_recipes=3"
+	if response := search(query + "&ids=false"); len(response.RecipeIDs) != 0 || len(registry.recipes) != 0 {
+		t.Errorf("search with ids=false returned %v and registered %d recipes", response.RecipeIDs, len(registry.recipes))
+	}
+
+	response := search(query)
+	if len(response.RecipeIDs) != len(response.Paths) {
+		t.Fatalf("%d IDs for %d recipes", len(response.RecipeIDs), len(response.Paths))
+	}
+	for _, id := range response.RecipeIDs {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/recipes/"+id, nil)
+		r.SetPathValue("id", id)
+		handleRecipe(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("recipe %s: status %d", id, w.Code)
+		}
+	}
+}
+
+// TestRecipeRegistryPersisted checks that every registered recipe is written
+// once and still resolves after the file is reloaded.
+func TestRecipeRegistryPersisted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "recipes.jsonl")
+	if err := LoadRecipeRegistry(filename); err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Close()
+
+	recipe := func(elem string) *Node {
+		return &Node{Element: elem, Left: &Node{Element: "Water"}, Right: &Node{Element: "Fire"}}
+	}
+	elems := []string{"Steam", "Mud", "Lava", "Steam"}
+	var ids []string
+	for _, elem := range elems {
+		ids = append(ids, registry.Register(recipe(elem)))
+	}
+	if ids[0] != ids[3] {
+		t.Errorf("same recipe registered as %s and %s", ids[0], ids[3])
+	}
+
+	registry.Close()
+	if err := LoadRecipeRegistry(filename); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		if node, ok := registry.Lookup(id); !ok || node.Element != elems[i] {
+			t.Errorf("reload lost recipe %s", id)
+		}
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("file holds %d recipes, want 3", lines)
+	}
+}
+
+// TestValidateLeaves checks that a tree and the equivalent step list get the
+// same verdict when a branch reuses an element another branch crafted, and
+// that a bare inventory element is a recipe of zero steps.
+func TestValidateLeaves(t *testing.T) {
+	loadDataset(t)
+
+	leaf := func(elem string) *RecipeTree { return &RecipeTree{Element: elem} }
+	land := &RecipeTree{Element: "Land", Left: leaf("Earth"), Right: leaf("Earth")}
+	tree := ValidateSubmission(ValidationRequest{Recipe: &RecipeTree{Element: "Continent", Left: land, Right: leaf("Land")}})
+	list := ValidateSubmission(ValidationRequest{Steps: []Step{
+		{Ingredients: []string{"Earth", "Earth"}, Result: "Land"},
+		{Ingredients: []string{"Land", "Land"}, Result: "Continent"},
+	}})
+	if !tree.Valid || !list.Valid {
+		t.Errorf("tree verdict %+v, list verdict %+v", tree, list)
+	}
+
+	if verdict := ValidateSubmission(ValidationRequest{Recipe: leaf("Land"), Inventory: []string{"Land"}}); !verdict.Valid || verdict.Steps != 0 {
+		t.Errorf("inventory element: %+v", verdict)
+	}
+	if verdict := ValidateSubmission(ValidationRequest{Recipe: leaf("Land")}); verdict.Valid {
+		t.Error("bare non-basic element accepted without inventory")
+	}
+}
+
+// cancellingRecorder cancels the request after the first event is written,
+// as if the client went away.
+type cancellingRecorder struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (w *cancellingRecorder) Write(data []byte) (int, error) {
+	defer w.cancel()
+	return w.ResponseRecorder.Write(data)
+}
+
+// TestSearchStreamCancelled checks that /search/stream stops sending once
+// the client goes away, and that the search itself stops early.
+func TestSearchStreamCancelled(t *testing.T) {
+	loadDataset(t)
+
+	for _, mode := range []string{"recipe_mode=single&mode=bfs", "recipe_mode=single&mode=dfs",
+		"recipe_mode=single&mode=bidirectional", "recipe_mode=multiple&mode=dfs&max_recipes=50"} {
+		query := "element=Golem&" + mode
+
+		ctx, cancel := context.WithCancel(context.Background())
+		w := &cancellingRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+		handleSearchStream(w, httptest.NewRequest(http.MethodGet, "/search/stream?"+query, nil).WithContext(ctx))
+		if events := strings.Count(w.Body.String(), "event: "); events != 1 {
+			t.Errorf("%s: %d events sent, want 1", query, events)
+		}
+
+		count := func(cancelEarly bool) (int, error) {
+			values, _ := url.ParseQuery(query)
+			req, err := parseSearchRequest(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			req.ctx = ctx
+			var mu sync.Mutex
+			events := 0
+			_, err = runSearch(req, func(SearchEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				events++
+				if cancelEarly {
+					stop()
+				}
+			})
+			return events, err
+		}
+		full, err := count(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cut, err := count(true)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: cancelled search returned %v", query, err)
+		}
+		if cut*2 > full {
+			t.Errorf("%s: cancelled search reported %d of %d events", query, cut, full)
+		}
+	}
+}
+
+// TestAllowedOrigins checks that CORS and the WebSocket handshake apply the
+// same origin allowlist.
+func TestAllowedOrigins(t *testing.T) {
+	loadDataset(t)
+	defer func(origins []string) { AllowedOrigins = origins }(AllowedOrigins)
+	AllowedOrigins = []string{"http://app.example"}
+
+	handshake := func(origin string) int {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example/search/session?element=Mud&mode=bfs", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		handleSearchSession(w, r)
+		return w.Code
+	}
+	// The recorder cannot be hijacked, so a handshake that gets past the
+	// origin check fails with 500 instead.
+	for origin, want := range map[string]int{
+		"http://evil.example": http.StatusForbidden,
+		"http://app.example":  http.StatusInternalServerError,
+		"http://api.example":  http.StatusInternalServerError,
+		"":                    http.StatusInternalServerError,
+	} {
+		if code := handshake(origin); code != want {
+			t.Errorf("handshake from %q: status %d, want %d", origin, code, want)
+		}
+	}
+
+	for origin, want := range map[string]string{"http://evil.example": "", "http://app.example": "http://app.example"} {
+		r := httptest.NewRequest(http.MethodOptions, "/search", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		enableCORS(handleSearch)(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("CORS for %q: allowed %q, want %q", origin, got, want)
+		}
+	}
+}
+
+// TestMultipleSearchSkips checks that multiple-recipe searches report every
+// combination they pass over, as the single-recipe searches do.
+func TestMultipleSearchSkips(t *testing.T) {
+	loadDataset(t)
+
+	for _, mode := range []string{"bfs", "dfs", "bidirectional"} {
+		query := url.Values{"element": {"Golem"}, "mode": {mode}, "recipe_mode": {"multiple"}, "max_recipes": {"5"}}
+		req, err := parseSearchRequest(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trace, err := RecordSearch(req, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		skipped := make(map[string]bool)
+		expanded := make(map[string]bool)
+		for _, entry := range trace.Entries {
+			switch entry.Type {
+			case EventSkip:
+				c := Combination{Root: entry.Element, Left: entry.Left, Right: entry.Right}
+				if req.cons.rejection(c) == "" {
+					t.Errorf("%s: allowed combination %s = %s + %s reported as skipped", mode, c.Root, c.Left, c.Right)
+				}
+				skipped[c.Root] = true
+			case EventVisit:
+				if entry.Direction != "forward" && !isBasic(entry.Element) {
+					expanded[entry.Element] = true
+				}
+			}
+		}
+		for elem := range expanded {
+			rejected := false
+			for _, c := range combinations[elem] {
+				rejected = rejected || req.cons.rejection(c) != ""
+			}
+			if rejected && !skipped[elem] {
+				t.Errorf("%s: no skip events for %s", mode, elem)
+			}
+		}
+		if len(skipped) == 0 {
+			t.Errorf("%s: no skip events at all", mode)
+		}
+	}
+}
+
+// TestLogCaptureBounded checks that a debug capture keeps at most
+// maxCapturedLogs records, shared by its clones, and counts the rest.
+func TestLogCaptureBounded(t *testing.T) {
+	capture := newLogCapture(slog.NewTextHandler(io.Discard, nil))
+	log := slog.New(capture)
+	child := log.With("search", "Golem")
+	for i := 0; i < maxCapturedLogs+5; i++ {
+		if i%2 == 0 {
+			log.Debug("event", "i", i)
+		} else {
+			child.Debug("event", "i", i)
+		}
+	}
+
+	entries, dropped := capture.Entries()
+	if len(entries) != maxCapturedLogs || dropped != 5 {
+		t.Errorf("%d entries and %d dropped, want %d and 5", len(entries), dropped, maxCapturedLogs)
+	}
+}
+
+// TestMultipleDFSCyclicPolicy checks that multiple-recipe DFS finds the
+// elements single-recipe DFS finds under a policy that allows cycles.
+func TestMultipleDFSCyclicPolicy(t *testing.T) {
+	loadDataset(t)
+
+	for _, recipeMode := range []string{"single", "multiple"} {
+		query := "element=Airplane&mode=dfs&policy=none&timing=false&recipe_mode=" + recipeMode
+		w := httptest.NewRecorder()
+		handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+		var response SearchResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if !response.Found {
+			t.Errorf("%s: no recipe", query)
+		}
+	}
+}
+
+// TestSearchLogsCaptured checks that the searches log to the request logger,
+// so their records show up in a debug=true response.
+func TestSearchLogsCaptured(t *testing.T) {
+	loadDataset(t)
+	defer func(capacity int64) { RecipeCacheBytes = capacity }(RecipeCacheBytes)
+	RecipeCacheBytes = 0
+	recipeCache.Reset()
+
+	for _, recipeMode := range []string{"single", "multiple"} {
+		for _, mode := range []string{"bfs", "dfs", "bidirectional"} {
+			if recipeMode == "single" && mode == "dfs" {
+				continue
+			}
+			query := "element=Brick&debug=true&mode=" + mode + "&recipe_mode=" + recipeMode
+			w := httptest.NewRecorder()
+			handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+			var response SearchResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+			finished := false
+			for _, entry := range response.Logs {
+				if entry.Message == "search finished" && entry.Attrs["algorithm"] == mode && entry.Attrs["recipe_mode"] == recipeMode {
+					finished = true
+				}
+			}
+			if !finished {
+				t.Errorf("%s: no search finished record from the %s search", query, mode)
+			}
+		}
+	}
+}
+
+// TestMetricsCountOutcomes checks that /metrics counts every search and
+// comparison request by outcome, failed ones included.
+func TestMetricsCountOutcomes(t *testing.T) {
+	loadDataset(t)
+
+	counter := func(series string) int {
+		w := httptest.NewRecorder()
+		handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if value, ok := strings.CutPrefix(line, series+" "); ok {
+				var n int
+				fmt.Sscan(value, &n)
+				return n
+			}
+		}
+		return 0
+	}
+	requests := []struct {
+		handler http.HandlerFunc
+		query   string
+		series  string
+	}{
+		{handleSearch, "/search?element=Brick&mode=bfs&recipe_mode=single",
+			`littlealchemy_search_requests_total{mode="bfs",recipe_mode="single",outcome="found"}`},
+		{handleSearch, "/search?element=Nothing&mode=dfs&recipe_mode=single",
+			`littlealchemy_search_requests_total{mode="dfs",recipe_mode="single",outcome="not_found"}`},
+		{handleSearch, "/search?element=Brick&mode=bfs&recipe_mode=bogus",
+			`littlealchemy_search_requests_total{mode="bfs",recipe_mode="other",outcome="bad_request"}`},
+		{handleSearch, "/search?element=Lava&mode=bfs&recipe_mode=single&policy=none&include=Dragon,Phoenix,Unicorn,Vampire,Zombie,Yeti",
+			`littlealchemy_search_requests_total{mode="bfs",recipe_mode="single",outcome="gave_up"}`},
+		{handleCompare, "/compare?element=Brick",
+			`littlealchemy_compare_requests_total{outcome="found"}`},
+		{handleCompare, "/compare",
+			`littlealchemy_compare_requests_total{outcome="bad_request"}`},
+	}
+	for _, request := range requests {
+		before := counter(request.series)
+		request.handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, request.query, nil))
+		if after := counter(request.series); after != before+1 {
+			t.Errorf("%s: %s went from %d to %d", request.query, request.series, before, after)
+		}
+	}
+}
+
+// TestCompareSeed checks that /compare applies seed like /search does, so
+// each algorithm's answer matches the seeded /search one, rejects a bad seed,
+// and stops with the request's context.
+func TestCompareSeed(t *testing.T) {
+	loadDataset(t)
+
+	w := httptest.NewRecorder()
+	handleCompare(w, httptest.NewRequest(http.MethodGet, "/compare?element=Golem&seed=7", nil))
+	var comparison CompareResponse
+	if err := json.NewDecoder(w.Body).Decode(&comparison); err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range comparison.Results {
+		w := httptest.NewRecorder()
+		handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?element=Golem&recipe_mode=single&seed=7&mode="+result.Algorithm, nil))
+		var response SearchResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if !response.Found || fmt.Sprint(response.Paths[0]) != fmt.Sprint(result.Recipe) {
+			t.Errorf("%s: compare recipe %v, search recipe %v", result.Algorithm, result.Recipe, response.Paths)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handleCompare(w, httptest.NewRequest(http.MethodGet, "/compare?element=Golem&seed=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid seed: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CompareAlgorithms(ctx, "Golem", nil, false, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled comparison: error %v", err)
+	}
+}
+
+// getJSON serves a GET of target with handler, with name as the {name} path
+// value, and decodes a 200 answer into v. It returns the status code.
+func getJSON(t *testing.T, handler http.HandlerFunc, target, name string, v any) int {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.SetPathValue("name", name)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+	}
+	return w.Code
+}
+
+// TestUses checks that /uses lists every combination of the dataset that
+// takes Water, with its partner and whether the tier rule accepts it.
+func TestUses(t *testing.T) {
+	loadDataset(t)
+
+	var response struct {
+		Count int   `json:"count"`
+		Uses  []Use `json:"uses"`
+	}
+	if code := getJSON(t, handleUses, "/elements/Water/uses", "Water", &response); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+
+	want := 0
+	for _, combos := range combinations {
+		for _, c := range combos {
+			if c.Left == "Water" || c.Right == "Water" {
+				want++
+			}
+		}
+	}
+	if response.Count != want || len(response.Uses) != want {
+		t.Errorf("count %d, %d uses listed, want %d", response.Count, len(response.Uses), want)
+	}
+	for _, use := range response.Uses {
+		listed := false
+		for _, c := range combinations[use.Result] {
+			if comboMatches(c, "Water", use.With) {
+				listed = true
+			}
+		}
+		legal := tierMap["Water"] < use.Tier && tierMap[use.With] < use.Tier
+		if !listed || use.Tier != tierMap[use.Result] || use.Legal != legal {
+			t.Errorf("use %+v: in dataset %v, legal %v", use, listed, legal)
+		}
+	}
+
+	if code := getJSON(t, handleUses, "/elements/Nothing/uses", "Nothing", &response); code != http.StatusNotFound {
+		t.Errorf("unknown element: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+// TestDescendants checks that every descendant of Water is made by a legal
+// combination from an element one level closer, at its smallest depth.
+func TestDescendants(t *testing.T) {
+	loadDataset(t)
+
+	var limited, all struct {
+		Count       int          `json:"count"`
+		Descendants []Descendant `json:"descendants"`
+	}
+	if code := getJSON(t, handleDescendants, "/elements/Water/descendants?depth=2", "Water", &limited); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if code := getJSON(t, handleDescendants, "/elements/Water/descendants", "Water", &all); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if limited.Count != len(limited.Descendants) || limited.Count == 0 {
+		t.Fatalf("count %d, %d descendants listed", limited.Count, len(limited.Descendants))
+	}
+
+	depth := map[string]int{"Water": 0}
+	for _, d := range all.Descendants {
+		depth[d.Element] = d.Depth
+	}
+	for _, d := range limited.Descendants {
+		if d.Depth > 2 || depth[d.Element] != d.Depth {
+			t.Errorf("%s at depth %d, %d without a limit", d.Element, d.Depth, depth[d.Element])
+		}
+	}
+	for _, d := range all.Descendants {
+		made := false
+		for _, c := range combinations[d.Element] {
+			legal := tierMap[c.Left] < d.Tier && tierMap[c.Right] < d.Tier
+			for _, ingredient := range []string{c.Left, c.Right} {
+				if at, ok := depth[ingredient]; ok && at == d.Depth-1 && legal {
+					made = true
+				}
+			}
+		}
+		if !made {
+			t.Errorf("%s at depth %d is not made from an element at depth %d", d.Element, d.Depth, d.Depth-1)
+		}
+	}
+
+	if code := getJSON(t, handleDescendants, "/elements/Water/descendants?depth=0", "Water", &all); code != http.StatusBadRequest {
+		t.Errorf("depth=0: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// comboMatches reports whether c takes a and b as its ingredients, in either
+// order.
+func comboMatches(c Combination, a, b string) bool {
+	return (c.Left == a && c.Right == b) || (c.Left == b && c.Right == a)
+}
+
+// TestMandatoryIngredients checks Human's report against brute force: an
+// ingredient is mandatory exactly when Human cannot be crafted without it.
+func TestMandatoryIngredients(t *testing.T) {
+	loadDataset(t)
+
+	craftableWithout := func(avoid string) bool {
+		craftable := make(map[string]bool)
+		for elem := range tierMap {
+			craftable[elem] = isBasic(elem) && elem != avoid
+		}
+		for changed := true; changed; {
+			changed = false
+			for root, combos := range combinations {
+				if craftable[root] || root == avoid {
+					continue
+				}
+				for _, c := range combos {
+					tier := tierMap[root]
+					if tierMap[c.Left] < tier && tierMap[c.Right] < tier && craftable[c.Left] && craftable[c.Right] {
+						craftable[root] = true
+						changed = true
+						break
+					}
+				}
+			}
+		}
+		return craftable["Human"]
+	}
+
+	var report IngredientReport
+	if code := getJSON(t, handleMandatory, "/elements/Human/mandatory", "Human", &report); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if !report.Craftable || len(report.Mandatory) == 0 || len(report.Avoidable) == 0 {
+		t.Fatalf("report %+v", report)
+	}
+	for _, elem := range report.Mandatory {
+		if craftableWithout(elem) {
+			t.Errorf("%s is listed as mandatory, but Human can be made without it", elem)
+		}
+	}
+	for _, elem := range report.Avoidable {
+		if !craftableWithout(elem) {
+			t.Errorf("%s is listed as avoidable, but Human needs it", elem)
+		}
+	}
+
+	var without struct {
+		Without []struct {
+			Element  string `json:"element"`
+			Possible bool   `json:"possible"`
+		} `json:"without"`
+	}
+	query := "/elements/Human/mandatory?without=" + url.QueryEscape(report.Mandatory[0]+","+report.Avoidable[0])
+	if code := getJSON(t, handleMandatory, query, "Human", &without); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(without.Without) != 2 || without.Without[0].Possible || !without.Without[1].Possible {
+		t.Errorf("%s: %+v", query, without.Without)
+	}
+}
+
+// TestWhatIf checks /whatif against /search: the elements it reports lost
+// without Water have recipes, but none once Water is excluded, and the ones
+// it leaves out still have recipes that avoid Water.
+func TestWhatIf(t *testing.T) {
+	loadDataset(t)
+
+	type searchAnswer struct {
+		Found bool     `json:"found"`
+		Paths [][]Step `json:"paths"`
+	}
+	search := func(element, exclude string) searchAnswer {
+		var answer searchAnswer
+		query := "/search?mode=bfs&recipe_mode=single&element=" + url.QueryEscape(element) + "&exclude=" + exclude
+		if code := getJSON(t, handleSearch, query, "", &answer); code != http.StatusOK {
+			t.Fatalf("%s: status %d", query, code)
+		}
+		return answer
+	}
+
+	var report struct {
+		Unreachable []string       `json:"unreachable"`
+		Count       int            `json:"count"`
+		Each        []WhatIfReport `json:"each"`
+	}
+	if code := getJSON(t, handleWhatIf, "/whatif?exclude=Water", "", &report); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if report.Count != len(report.Unreachable) || report.Count == 0 {
+		t.Fatalf("count %d, %d elements listed", report.Count, len(report.Unreachable))
+	}
+	if len(report.Each) != 1 || report.Each[0].Removed != "Water" || len(report.Each[0].Unreachable) != report.Count {
+		t.Errorf("each %+v", report.Each)
+	}
+
+	unreachable := make(map[string]bool)
+	for i, elem := range report.Unreachable {
+		unreachable[elem] = true
+		if i < 20 && (!search(elem, "").Found || search(elem, "Water").Found) {
+			t.Errorf("%s is reported lost without Water", elem)
+		}
+	}
+	checked := 0
+	for _, elem := range sortedElements() {
+		if checked == 20 || unreachable[elem] || isBasic(elem) || elem == "Water" || !search(elem, "").Found {
+			continue
+		}
+		checked++
+		answer := search(elem, "Water")
+		if !answer.Found {
+			t.Errorf("%s has no recipe without Water, but is not reported lost", elem)
+			continue
+		}
+		for _, step := range answer.Paths[0] {
+			if step.Ingredients[0] == "Water" || step.Ingredients[1] == "Water" {
+				t.Errorf("%s: recipe uses the excluded Water", elem)
+			}
+		}
+	}
+
+	if code := getJSON(t, handleWhatIf, "/whatif?exclude_combo=Water%2BFire", "", &report); code != http.StatusOK {
+		t.Fatalf("exclude_combo: status %d", code)
+	}
+	if len(report.Each) != 1 || report.Each[0].Removed != comboKey("Water", "Fire") {
+		t.Errorf("exclude_combo: each %+v", report.Each)
+	}
+	for _, query := range []string{"/whatif", "/whatif?exclude_combo=Water"} {
+		if code := getJSON(t, handleWhatIf, query, "", &report); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
+
+// sortedElements returns the elements of the loaded dataset in name order.
+func sortedElements() []string {
+	elements := make([]string, 0, len(tierMap))
+	for elem := range tierMap {
+		elements = append(elements, elem)
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+// TestSearchConstraints checks that every single-recipe search honours
+// include, max_steps, max_depth and max_tier_jump, finding a recipe that
+// meets them where one exists and none where the limit is too tight.
+func TestSearchConstraints(t *testing.T) {
+	loadDataset(t)
+
+	type searchAnswer struct {
+		Found bool     `json:"found"`
+		Paths [][]Step `json:"paths"`
+	}
+	for _, mode := range []string{"bfs", "dfs", "bidirectional"} {
+		for _, c := range []struct {
+			query string
+			found bool
+			check func(path []Step) string
+		}{
+			{"element=Boat&include=Volcano", true, func(path []Step) string {
+				for _, step := range path {
+					if step.Result == "Volcano" {
+						return ""
+					}
+				}
+				return "no Volcano"
+			}},
+			{"element=Boat&max_steps=30", true, func(path []Step) string {
+				if len(path) > 30 {
+					return fmt.Sprintf("%d steps", len(path))
+				}
+				return ""
+			}},
+			{"element=Beach&max_tier_jump=2", true, func(path []Step) string {
+				for _, step := range path {
+					if step.Tiers.Result-step.Tiers.Left > 2 || step.Tiers.Result-step.Tiers.Right > 2 {
+						return fmt.Sprintf("%s jumps from tier %d and %d to %d", step.Result, step.Tiers.Left, step.Tiers.Right, step.Tiers.Result)
+					}
+				}
+				return ""
+			}},
+			{"element=Beach&max_depth=4", true, func(path []Step) string {
+				depth := make(map[string]int)
+				for _, step := range path {
+					depth[step.Result] = 1 + max(depth[step.Ingredients[0]], depth[step.Ingredients[1]])
+				}
+				if depth["Beach"] > 4 {
+					return fmt.Sprintf("depth %d", depth["Beach"])
+				}
+				return ""
+			}},
+			{"element=Beach&max_depth=3", false, nil},
+		} {
+			query := "/search?recipe_mode=single&mode=" + mode + "&" + c.query
+			var answer searchAnswer
+			if code := getJSON(t, handleSearch, query, "", &answer); code != http.StatusOK {
+				t.Errorf("%s: status %d", query, code)
+				continue
+			}
+			if answer.Found != c.found {
+				t.Errorf("%s: found %v, want %v", query, answer.Found, c.found)
+				continue
+			}
+			if answer.Found {
+				if problem := c.check(answer.Paths[0]); problem != "" {
+					t.Errorf("%s: %s", query, problem)
+				}
+			}
+		}
+	}
+
+	var answer searchAnswer
+	if code := getJSON(t, handleSearch, "/search?element=Beach&mode=bfs&recipe_mode=single&max_steps=0", "", &answer); code != http.StatusBadRequest {
+		t.Errorf("max_steps=0: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// TestPlan checks that /plan crafts every target once, each element after
+// its ingredients and with a legal combination, and that planning Golem
+// with Human, which it needs, saves steps over crafting them apart.
+func TestPlan(t *testing.T) {
+	loadDataset(t)
+
+	var plan Plan
+	if code := getJSON(t, handlePlan, "/plan?targets=Human,Golem&mode=bfs", "", &plan); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	made := make(map[string]bool)
+	for i, step := range plan.Steps {
+		legal := false
+		for _, c := range combinations[step.Result] {
+			tier := tierMap[step.Result]
+			if comboMatches(c, step.Ingredients[0], step.Ingredients[1]) && tierMap[c.Left] < tier && tierMap[c.Right] < tier {
+				legal = true
+			}
+		}
+		if !legal {
+			t.Errorf("step %d (%s = %s + %s) is not a legal combination", i, step.Result, step.Ingredients[0], step.Ingredients[1])
+		}
+		for _, ingredient := range step.Ingredients {
+			if !isBasic(ingredient) && !made[ingredient] {
+				t.Errorf("step %d uses %s before it is made", i, ingredient)
+			}
+		}
+		if made[step.Result] {
+			t.Errorf("step %d makes %s again", i, step.Result)
+		}
+		made[step.Result] = true
+	}
+	if !made["Human"] || !made["Golem"] || len(plan.Missing) != 0 {
+		t.Errorf("plan makes Human %v and Golem %v, missing %v", made["Human"], made["Golem"], plan.Missing)
+	}
+	if plan.CombinedSteps != len(plan.Steps) || plan.Saved != plan.SeparateSteps-plan.CombinedSteps || plan.Saved <= 0 {
+		t.Errorf("%d steps: combined %d, separate %d, saved %d", len(plan.Steps), plan.CombinedSteps, plan.SeparateSteps, plan.Saved)
+	}
+
+	for query, want := range map[string]int{
+		"/plan":                           http.StatusBadRequest,
+		"/plan?targets=Human&mode=random": http.StatusBadRequest,
+		"/plan?targets=Human,Nothing":     http.StatusNotFound,
+	} {
+		if code := getJSON(t, handlePlan, query, "", &plan); code != want {
+			t.Errorf("%s: status %d, want %d", query, code, want)
+		}
+	}
+}
+
+// TestCycles checks the cycle report against the raw combination graph:
+// each component is exactly the elements that both reach and are reached by
+// its first one, each cycle follows edges of the dataset within a single
+// component, and the strict rule breaks a cycle exactly where no combination
+// for that edge is tier-legal.
+func TestCycles(t *testing.T) {
+	loadDataset(t)
+
+	edges := make(map[string]map[string]bool)
+	reverse := make(map[string]map[string]bool)
+	for _, combos := range combinations {
+		for _, c := range combos {
+			for _, ingredient := range []string{c.Left, c.Right} {
+				if ingredient == "" {
+					continue
+				}
+				if edges[ingredient] == nil {
+					edges[ingredient] = make(map[string]bool)
+				}
+				if reverse[c.Root] == nil {
+					reverse[c.Root] = make(map[string]bool)
+				}
+				edges[ingredient][c.Root] = true
+				reverse[c.Root][ingredient] = true
+			}
+		}
+	}
+	reach := func(graph map[string]map[string]bool, start string) map[string]bool {
+		seen := map[string]bool{start: true}
+		stack := []string{start}
+		for len(stack) > 0 {
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for next := range graph[current] {
+				if !seen[next] {
+					seen[next] = true
+					stack = append(stack, next)
+				}
+			}
+		}
+		return seen
+	}
+	strictEdge := func(from, to string) bool {
+		for _, c := range combinations[to] {
+			if (c.Left == from || c.Right == from) && tierMap[c.Left] < tierMap[to] && tierMap[c.Right] < tierMap[to] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var report CycleReport
+	if code := getJSON(t, handleCycles, "/analysis/cycles", "", &report); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(report.Components) == 0 || len(report.Cycles) == 0 || report.Truncated {
+		t.Fatalf("%d components, %d cycles, truncated %v", len(report.Components), len(report.Cycles), report.Truncated)
+	}
+
+	component := make(map[string]int)
+	for i, members := range report.Components {
+		forward, backward := reach(edges, members[0]), reach(reverse, members[0])
+		want := 0
+		for elem := range forward {
+			if backward[elem] {
+				want++
+			}
+		}
+		for _, elem := range members {
+			if !forward[elem] || !backward[elem] {
+				t.Errorf("component %d: %s is not strongly connected to %s", i, elem, members[0])
+			}
+			component[elem] = i
+		}
+		if len(members) != want {
+			t.Errorf("component %d has %d elements, want %d", i, len(members), want)
+		}
+	}
+
+	for _, cycle := range report.Cycles {
+		var broken []string
+		for i, from := range cycle.Elements {
+			to := cycle.Elements[(i+1)%len(cycle.Elements)]
+			if !edges[from][to] || component[from] != component[cycle.Elements[0]] {
+				t.Errorf("cycle %v: no edge %s -> %s inside its component", cycle.Elements, from, to)
+			}
+			if !strictEdge(from, to) {
+				broken = append(broken, from+" -> "+to)
+			}
+		}
+		loopsUnder := strings.Join(cycle.LoopsUnder, ",")
+		if strings.Join(broken, ",") != strings.Join(cycle.BrokenEdges, ",") ||
+			strings.Contains(loopsUnder, "strict") != (len(broken) == 0) || !strings.Contains(loopsUnder, "none") {
+			t.Errorf("cycle %v: broken %v, loops under %v; want broken %v", cycle.Elements, cycle.BrokenEdges, cycle.LoopsUnder, broken)
+		}
+	}
+
+	if code := getJSON(t, handleCycles, "/analysis/cycles?limit=1", "", &report); code != http.StatusOK {
+		t.Fatalf("limit=1: status %d", code)
+	}
+	if len(report.Cycles) != 1 || !report.Truncated {
+		t.Errorf("limit=1: %d cycles, truncated %v", len(report.Cycles), report.Truncated)
+	}
+	if code := getJSON(t, handleCycles, "/analysis/cycles?limit=0", "", &report); code != http.StatusBadRequest {
+		t.Errorf("limit=0: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// TestMultipleRecipesScheduling checks that multiple-recipe answers do not
+// depend on how the work is scheduled: a single worker and a large pool
+// return the same recipes in the same order, with the same visited count.
+// Each run excludes a different element the dataset does not have, which
+// changes nothing but keeps any cached answer out of the comparison.
+func TestMultipleRecipesScheduling(t *testing.T) {
+	loadDataset(t)
+	defer func(workers int) { MultiWorkers = workers }(MultiWorkers)
+
+	type searchAnswer struct {
+		Found bool     `json:"found"`
+		Steps int      `json:"steps"`
+		Paths [][]Step `json:"paths"`
+	}
+	for _, target := range []string{"Human", "Golem", "Beach"} {
+		for _, mode := range []string{"bfs", "dfs", "bidirectional"} {
+			var answers [2]searchAnswer
+			for run, workers := range []int{1, 8} {
+				MultiWorkers = workers
+				query := fmt.Sprintf("/search?element=%s&mode=%s&recipe_mode=multiple&max_recipes=8&exclude=Unused%d", target, mode, run)
+				if code := getJSON(t, handleSearch, query, "", &answers[run]); code != http.StatusOK {
+					t.Fatalf("%s: status %d", query, code)
+				}
+			}
+			if !answers[0].Found || fmt.Sprint(answers[0]) != fmt.Sprint(answers[1]) {
+				t.Errorf("%s %s: %d recipes visiting %d with one worker, %d visiting %d with eight",
+					target, mode, len(answers[0].Paths), answers[0].Steps, len(answers[1].Paths), answers[1].Steps)
+			}
+		}
+	}
+}
+
+// TestRecipeCacheServesRepeats checks that a repeated search is answered
+// from the recipe cache with the same result, and that /cache/stats reports
+// the cache counters.
+func TestRecipeCacheServesRepeats(t *testing.T) {
+	loadDataset(t)
+
+	type searchAnswer struct {
+		Found bool     `json:"found"`
+		Steps int      `json:"steps"`
+		Paths [][]Step `json:"paths"`
+	}
+	// No other test excludes Unused036, so the first search is a miss.
+	const query = "/search?element=Golem&mode=bfs&recipe_mode=single&exclude=Unused036"
+	var answers [2]searchAnswer
+	var stats [3]CacheStats
+	stats[0] = recipeCache.Stats()
+	for i := range answers {
+		if code := getJSON(t, handleSearch, query, "", &answers[i]); code != http.StatusOK {
+			t.Fatalf("status %d", code)
+		}
+		stats[i+1] = recipeCache.Stats()
+	}
+
+	if stats[1].Hits != stats[0].Hits || stats[1].Misses == stats[0].Misses || stats[1].Entries <= stats[0].Entries {
+		t.Errorf("first search: %+v, then %+v", stats[0], stats[1])
+	}
+	if stats[2].Hits != stats[1].Hits+1 || stats[2].Misses != stats[1].Misses || stats[2].Entries != stats[1].Entries {
+		t.Errorf("repeated search: %+v, then %+v", stats[1], stats[2])
+	}
+	first, _ := json.Marshal(answers[0])
+	repeat, _ := json.Marshal(answers[1])
+	if !answers[0].Found || string(first) != string(repeat) {
+		t.Errorf("cached answer %s, want %s", repeat, first)
+	}
+
+	w := httptest.NewRecorder()
+	handleCacheStats(w, httptest.NewRequest(http.MethodGet, "/cache/stats", nil))
+	var served CacheStats
+	if err := json.NewDecoder(w.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if served != stats[2] || served.Dataset != datasetHash || served.Capacity != RecipeCacheBytes {
+		t.Errorf("/cache/stats served %+v, want %+v", served, stats[2])
+	}
+}
+
+// TestSolutionIndex checks that a loaded index answers unconstrained
+// single-recipe searches with the recipes the live searches find, that
+// constrained searches still run live, and that an index built for another
+// dataset is rejected.
+func TestSolutionIndex(t *testing.T) {
+	loadDataset(t)
+	defer LoadIndex("")
+
+	type searchAnswer struct {
+		Found bool     `json:"found"`
+		Steps int      `json:"steps"`
+		Paths [][]Step `json:"paths"`
+	}
+	search := func(query string) string {
+		t.Helper()
+		var answer searchAnswer
+		if code := getJSON(t, handleSearch, query, "", &answer); code != http.StatusOK {
+			t.Fatalf("%s: status %d", query, code)
+		}
+		encoded, _ := json.Marshal(answer)
+		return string(encoded)
+	}
+
+	targets := []string{"Human", "Golem", "Beach", "Time"}
+	live := make(map[string]string)
+	for _, target := range targets {
+		for _, mode := range indexModes {
+			query := fmt.Sprintf("/search?element=%s&mode=%s&recipe_mode=single", target, mode)
+			live[query] = search(query)
+		}
+	}
+
+	index := BuildIndex()
+	// A visited count no search reports shows which answers the index served.
+	const marker = 987654
+	best := index.Elements["Golem"].Best["bfs"]
+	best.Visited = marker
+	index.Elements["Golem"].Best["bfs"] = best
+
+	filename := filepath.Join(t.TempDir(), "index.json")
+	if err := WriteIndex(index, filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadIndex(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, want := range live {
+		if query == "/search?element=Golem&mode=bfs&recipe_mode=single" {
+			continue
+		}
+		if got := search(query); got != want {
+			t.Errorf("%s: indexed %s, live %s", query, got, want)
+		}
+	}
+	var served searchAnswer
+	getJSON(t, handleSearch, "/search?element=Golem&mode=bfs&recipe_mode=single", "", &served)
+	if served.Steps != marker {
+		t.Errorf("Golem bfs visited %d, want the indexed %d", served.Steps, marker)
+	}
+	getJSON(t, handleSearch, "/search?element=Golem&mode=bfs&recipe_mode=single&exclude=Unused037", "", &served)
+	if served.Steps == marker {
+		t.Error("constrained search answered from the index")
+	}
+
+	index.Dataset = "other"
+	if err := WriteIndex(index, filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadIndex(filename); err == nil {
+		t.Error("loaded an index built for another dataset")
+	}
+	if solutionIndex != nil {
+		t.Error("rejected index left loaded")
+	}
+}
+
+// recipeLookup is the body of /recipes/{id}.
+type recipeLookup struct {
+	ID     string `json:"id"`
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error"`
+	Path   []Step `json:"path"`
+	Target struct {
+		Element string `json:"element"`
+	} `json:"target"`
+}
+
+// TestRecipeLookup checks that every recipe a search returns resolves by ID
+// to the same steps, that an ID does not depend on ingredient order, and that
+// a resolved recipe is checked against the request's constraints.
+func TestRecipeLookup(t *testing.T) {
+	loadDataset(t)
+
+	var response struct {
+		Paths     [][]Step `json:"paths"`
+		RecipeIDs []string `json:"recipeIds"`
+	}
+	query := "/search?element=Human&mode=bfs&recipe_mode=multiple&max_recipes=5&ids=true"
+	if code := getJSON(t, handleSearch, query, "", &response); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(response.Paths) == 0 || len(response.RecipeIDs) != len(response.Paths) {
+		t.Fatalf("%d IDs for %d recipes", len(response.RecipeIDs), len(response.Paths))
+	}
+	seen := make(map[string]bool)
+	for i, id := range response.RecipeIDs {
+		if len(id) != recipeIDLength || seen[id] {
+			t.Errorf("recipe %d: ID %q is malformed or repeated", i, id)
+		}
+		seen[id] = true
+
+		var recipe recipeLookup
+		if code := getRecipe(t, id, "", &recipe); code != http.StatusOK {
+			t.Errorf("recipe %s: status %d", id, code)
+			continue
+		}
+		if recipe.ID != id || !recipe.Valid || recipe.Target.Element != "Human" ||
+			fmt.Sprint(recipe.Path) != fmt.Sprint(response.Paths[i]) {
+			t.Errorf("recipe %s resolved to %+v, want the steps %v", id, recipe, response.Paths[i])
+		}
+	}
+
+	water, fire := &Node{Element: "Water"}, &Node{Element: "Fire"}
+	steam := &Node{Element: "Steam", Left: water, Right: fire}
+	mirrored := &Node{Element: "Steam", Left: fire, Right: water}
+	if RecipeID(steam) != RecipeID(mirrored) {
+		t.Errorf("mirrored recipes got IDs %s and %s", RecipeID(steam), RecipeID(mirrored))
+	}
+	id := registry.Register(mirrored)
+	var recipe recipeLookup
+	if code := getRecipe(t, id, "exclude_combo=Water%2BFire", &recipe); code != http.StatusOK || recipe.Valid || recipe.Error == "" {
+		t.Errorf("Steam without Water+Fire: status %d, %+v", code, recipe)
+	}
+	if code := getRecipe(t, "000000000000", "", &recipe); code != http.StatusNotFound {
+		t.Errorf("unknown ID: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+// getRecipe requests /recipes/{id} with query and decodes a 200 response
+// into v.
+func getRecipe(t *testing.T, id, query string, v any) int {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/recipes/"+id+"?"+query, nil)
+	r.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	handleRecipe(w, r)
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatalf("recipe %s: %v", id, err)
+		}
+	}
+	return w.Code
+}
+
+// postValidate submits body to /validate and decodes a 200 verdict into v.
+func postValidate(t *testing.T, body any, v *Verdict) int {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handleValidate(w, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(data)))
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+// TestValidate checks that /validate accepts the recipes the searches return
+// and reports each kind of mistake at the step that makes it.
+func TestValidate(t *testing.T) {
+	loadDataset(t)
+
+	var response struct {
+		Paths [][]Step `json:"paths"`
+	}
+	if code := getJSON(t, handleSearch, "/search?element=Human&mode=bfs&recipe_mode=multiple&max_recipes=3", "", &response); code != http.StatusOK {
+		t.Fatalf("search: status %d", code)
+	}
+	for _, path := range response.Paths {
+		var verdict Verdict
+		if code := postValidate(t, ValidationRequest{Steps: path}, &verdict); code != http.StatusOK {
+			t.Fatalf("status %d", code)
+		}
+		if !verdict.Valid || verdict.Target != "Human" || verdict.Steps != len(path) || verdict.FirstError != nil {
+			t.Errorf("searched recipe %v: %+v", path, verdict)
+		}
+	}
+
+	// The first combinations in dataset order that break only the tier rule,
+	// and that use a crafted ingredient under it.
+	var upward, crafted *Combination
+	for _, elem := range sortedElements() {
+		for _, c := range combinations[elem] {
+			switch {
+			case upward == nil && !IsLowerTier(c):
+				upward = &c
+			case crafted == nil && IsLowerTier(c) && !isBasic(c.Left):
+				crafted = &c
+			}
+		}
+	}
+	if upward == nil || crafted == nil {
+		t.Fatal("dataset has no combination to test with")
+	}
+
+	cases := []struct {
+		name      string
+		request   ValidationRequest
+		kind      string
+		path      string
+		available bool
+	}{
+		{"no combination", ValidationRequest{Steps: []Step{{Ingredients: []string{"Water", "Water"}, Result: "Fire"}}},
+			errNoCombo, "steps[0]", true},
+		{"tier rule", ValidationRequest{Steps: []Step{{Ingredients: []string{upward.Left, upward.Right}, Result: upward.Root}},
+			Inventory: []string{upward.Left, upward.Right}}, errTierRule, "steps[0]", true},
+		{"unavailable", ValidationRequest{Recipe: &RecipeTree{Element: crafted.Root,
+			Left: &RecipeTree{Element: crafted.Left}, Right: &RecipeTree{Element: crafted.Right}}},
+			errUnavailable, "recipe.left", false},
+	}
+	for _, tc := range cases {
+		var verdict Verdict
+		if code := postValidate(t, tc.request, &verdict); code != http.StatusOK {
+			t.Fatalf("%s: status %d", tc.name, code)
+		}
+		if verdict.Valid || verdict.FirstError == nil || verdict.FirstError.Kind != tc.kind ||
+			verdict.FirstError.Step != 1 || verdict.FirstError.Path != tc.path || verdict.IngredientsAvailable != tc.available {
+			t.Errorf("%s: %+v", tc.name, verdict)
+		}
+	}
+
+	unavailable := cases[2].request
+	unavailable.Inventory = []string{crafted.Left, crafted.Right}
+	var verdict Verdict
+	if postValidate(t, unavailable, &verdict); !verdict.Valid {
+		t.Errorf("with %s in the inventory: %+v", crafted.Left, verdict)
+	}
+
+	both := ValidationRequest{Recipe: &RecipeTree{Element: "Water"}, Steps: response.Paths[0]}
+	if code := postValidate(t, both, &verdict); code != http.StatusBadRequest {
+		t.Errorf("recipe and steps: status %d, want %d", code, http.StatusBadRequest)
+	}
+	w := httptest.NewRecorder()
+	handleValidate(w, httptest.NewRequest(http.MethodGet, "/validate", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// TestCraftingOrders checks every kind of crafting order of a few Human
+// recipes: each element is crafted once, after its ingredients, with the
+// step numbers, layers and inventory counts that follow from the steps.
+func TestCraftingOrders(t *testing.T) {
+	loadDataset(t)
+
+	for kind := range orderKinds {
+		var response struct {
+			Paths  [][]Step        `json:"paths"`
+			Orders []CraftingOrder `json:"orders"`
+		}
+		query := "/search?element=Human&mode=bfs&recipe_mode=multiple&max_recipes=3&order=" + kind
+		if code := getJSON(t, handleSearch, query, "", &response); code != http.StatusOK {
+			t.Fatalf("%s: status %d", query, code)
+		}
+		if len(response.Orders) != len(response.Paths) || len(response.Orders) == 0 {
+			t.Fatalf("%s: %d orders for %d recipes", kind, len(response.Orders), len(response.Paths))
+		}
+
+		for i, order := range response.Orders {
+			crafted := make(map[string]bool)
+			for _, step := range response.Paths[i] {
+				crafted[step.Result] = true
+			}
+			if order.Kind != kind || len(order.Steps) != len(crafted) {
+				t.Errorf("%s order %d: kind %s, %d steps for %d crafted elements", kind, i, order.Kind, len(order.Steps), len(crafted))
+				continue
+			}
+
+			number := make(map[string]int)
+			layer := make(map[string]int)
+			layers, peak := 0, 0
+			for j, step := range order.Steps {
+				where := fmt.Sprintf("%s order %d step %d", kind, i, j+1)
+				if step.Number != j+1 || number[step.Result] != 0 || !crafted[step.Result] {
+					t.Errorf("%s: number %d, crafts %s again or outside the recipe", where, step.Number, step.Result)
+				}
+				wantLayer := 1
+				for k, ingredient := range step.Ingredients {
+					if !isBasic(ingredient) && number[ingredient] == 0 {
+						t.Errorf("%s uses %s before it is made", where, ingredient)
+					}
+					if step.Sources[k] != number[ingredient] {
+						t.Errorf("%s: source %d for %s, made by step %d", where, step.Sources[k], ingredient, number[ingredient])
+					}
+					wantLayer = max(wantLayer, layer[ingredient]+1)
+				}
+				if step.Layer != wantLayer {
+					t.Errorf("%s: layer %d, want %d", where, step.Layer, wantLayer)
+				}
+				if kind == "layered" && j > 0 && step.Layer < order.Steps[j-1].Layer {
+					t.Errorf("%s: layer %d after layer %d", where, step.Layer, order.Steps[j-1].Layer)
+				}
+				number[step.Result] = step.Number
+				layer[step.Result] = step.Layer
+
+				holding := 0
+				for elem := range number {
+					needed := elem == "Human"
+					for _, later := range order.Steps[j+1:] {
+						needed = needed || later.Ingredients[0] == elem || later.Ingredients[1] == elem
+					}
+					if needed {
+						holding++
+					}
+				}
+				if step.Holding != holding {
+					t.Errorf("%s: holding %d, want %d", where, step.Holding, holding)
+				}
+				layers, peak = max(layers, step.Layer), max(peak, holding)
+			}
+			if number["Human"] != len(order.Steps) || order.Layers != layers || order.PeakInventory != peak {
+				t.Errorf("%s order %d: Human made by step %d of %d, %d layers, peak %d; want %d layers, peak %d",
+					kind, i, number["Human"], len(order.Steps), order.Layers, order.PeakInventory, layers, peak)
+			}
+		}
+	}
+
+	var response struct{}
+	if code := getJSON(t, handleSearch, "/search?element=Human&mode=bfs&recipe_mode=single&order=random", "", &response); code != http.StatusBadRequest {
+		t.Errorf("order=random: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// TestSearchStream checks that /search/stream sends well-formed events for
+// every algorithm and ends with a result event that carries the answer
+// /search gives for the same query.
+func TestSearchStream(t *testing.T) {
+	loadDataset(t)
+
+	type searchAnswer struct {
+		Found bool     `json:"found"`
+		Steps int      `json:"steps"`
+		Paths [][]Step `json:"paths"`
+	}
+	type streamEvent struct {
+		Type    string        `json:"type"`
+		Element string        `json:"element"`
+		Left    string        `json:"left"`
+		Right   string        `json:"right"`
+		Result  *searchAnswer `json:"result"`
+	}
+
+	// No other test excludes Unused042, so neither request is answered from
+	// the recipe cache.
+	for _, mode := range []string{"recipe_mode=single&mode=bfs", "recipe_mode=single&mode=dfs",
+		"recipe_mode=single&mode=bidirectional", "recipe_mode=multiple&mode=bfs&max_recipes=5"} {
+		query := "element=Golem&exclude=Unused042&" + mode
+		w := httptest.NewRecorder()
+		handleSearchStream(w, httptest.NewRequest(http.MethodGet, "/search/stream?"+query, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("%s: status %d, content type %q", mode, w.Code, w.Header().Get("Content-Type"))
+		}
+
+		var events []streamEvent
+		counts := make(map[string]int)
+		scanner := bufio.NewScanner(w.Body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			name, ok := strings.CutPrefix(scanner.Text(), "event: ")
+			if !ok {
+				continue
+			}
+			scanner.Scan()
+			var event streamEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(scanner.Text(), "data: ")), &event); err != nil {
+				t.Fatalf("%s: %v", mode, err)
+			}
+			if event.Type != name {
+				t.Errorf("%s: %q event carries type %q", mode, name, event.Type)
+			}
+			events = append(events, event)
+			counts[event.Type]++
+		}
+		if len(events) == 0 || events[len(events)-1].Type != EventResult || counts[EventResult] != 1 {
+			t.Fatalf("%s: event counts %v, want one result event last", mode, counts)
+		}
+		if counts[EventVisit] == 0 || counts[EventRecipe] == 0 {
+			t.Errorf("%s: event counts %v, want visits and recipes", mode, counts)
+		}
+		if strings.HasSuffix(mode, "bidirectional") && counts[EventMeet] == 0 {
+			t.Errorf("%s: the two halves never met", mode)
+		}
+
+		var want searchAnswer
+		if code := getJSON(t, handleSearch, "/search?"+query, "", &want); code != http.StatusOK {
+			t.Fatalf("%s: search status %d", mode, code)
+		}
+		got := events[len(events)-1].Result
+		if got == nil || fmt.Sprint(*got) != fmt.Sprint(want) {
+			t.Errorf("%s: streamed result %+v, /search %+v", mode, got, want)
+			continue
+		}
+
+		// The last step of each streamed recipe was reported as it was built.
+		for _, path := range got.Paths {
+			last := path[len(path)-1]
+			built := false
+			for _, event := range events {
+				built = built || (event.Type == EventRecipe && event.Element == last.Result &&
+					comboMatches(Combination{Left: event.Left, Right: event.Right}, last.Ingredients[0], last.Ingredients[1]))
+			}
+			if !built {
+				t.Errorf("%s: no recipe event for %v", mode, last)
+			}
+		}
+	}
+}
+
+// sessionClient is the client end of a /search/session WebSocket, enough of
+// RFC 6455 to send masked text frames and read the server's unmasked ones.
+type sessionClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// sessionState is the part of a session message the tests look at.
+type sessionState struct {
+	Type   string          `json:"type"`
+	Action string          `json:"action"`
+	State  *SearchSnapshot `json:"state"`
+	Events []SearchEvent   `json:"events"`
+	Result *struct {
+		Found bool     `json:"found"`
+		Steps int      `json:"steps"`
+		Paths [][]Step `json:"paths"`
+	} `json:"result"`
+	Error string `json:"error"`
+}
+
+// dialSession opens a session on server for query.
+func dialSession(t *testing.T, server *httptest.Server, query string) *sessionClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "GET /search/session?%s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", query, server.Listener.Addr())
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: status %d, accept %q", response.StatusCode, response.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &sessionClient{t: t, conn: conn, reader: reader}
+}
+
+func (c *sessionClient) send(action SessionAction) {
+	c.t.Helper()
+	payload, _ := json.Marshal(action)
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame := append([]byte{0x81, 0x80 | byte(len(payload))}, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the next message, or nil once the server closes.
+func (c *sessionClient) read() *sessionState {
+	c.t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	if head[0]&0x0F == 0x8 {
+		return nil
+	}
+	var message sessionState
+	if err := json.Unmarshal(payload, &message); err != nil {
+		c.t.Fatal(err)
+	}
+	return &message
+}
+
+// TestSearchSession steps, runs and cancels a session for every algorithm,
+// checking that the state advances with each step and that the finished
+// session gives the answer /search gives.
+func TestSearchSession(t *testing.T) {
+	loadDataset(t)
+	server := httptest.NewServer(http.HandlerFunc(handleSearchSession))
+	defer server.Close()
+
+	for _, mode := range []string{"bfs", "dfs", "bidirectional"} {
+		// No other test excludes Unused043, so /search runs live.
+		query := "element=Golem&exclude=Unused043&mode=" + mode
+		client := dialSession(t, server, query)
+
+		start := client.read()
+		if start.Type != "state" || start.Action != "start" || start.State == nil || start.State.Done {
+			t.Fatalf("%s: first message %+v", mode, start)
+		}
+		visited := 0
+		for range 3 {
+			client.send(SessionAction{Action: "step"})
+			message := client.read()
+			if message.Action != "step" || message.State == nil || message.State.VisitedCount <= visited || len(message.Events) == 0 {
+				t.Fatalf("%s: step after %d visited gave %+v", mode, visited, message)
+			}
+			visited = message.State.VisitedCount
+		}
+
+		client.send(SessionAction{Action: "dance"})
+		if message := client.read(); message.Type != "error" || !strings.Contains(message.Error, "dance") {
+			t.Errorf("%s: unknown action answered with %+v", mode, message)
+		}
+
+		client.send(SessionAction{Action: "run"})
+		var last *sessionState
+		for last == nil || last.Result == nil {
+			last = client.read()
+			if last.Type != "state" || last.Action != "run" {
+				t.Fatalf("%s: run pushed %+v", mode, last)
+			}
+		}
+		if !last.State.Done || !last.State.Found {
+			t.Errorf("%s: finished in state %+v", mode, last.State)
+		}
+
+		var want struct {
+			Found bool     `json:"found"`
+			Steps int      `json:"steps"`
+			Paths [][]Step `json:"paths"`
+		}
+		if code := getJSON(t, handleSearch, "/search?recipe_mode=single&"+query, "", &want); code != http.StatusOK {
+			t.Fatalf("%s: search status %d", mode, code)
+		}
+		if fmt.Sprint(*last.Result) != fmt.Sprint(want) {
+			t.Errorf("%s: session result %+v, /search %+v", mode, *last.Result, want)
+		}
+
+		client.send(SessionAction{Action: "cancel"})
+		if message := client.read(); message == nil || message.Action != "cancel" {
+			t.Errorf("%s: cancel answered with %+v", mode, message)
+		}
+		if client.read() != nil {
+			t.Errorf("%s: session still open after cancel", mode)
+		}
+		client.conn.Close()
+	}
+}
+
+// TestSearchTrace checks that /search/trace records the same single-recipe
+// search identically in both formats and on every run, that the trace ends
+// with the /search answer, and that a replay lists every entry and the
+// recipe found.
+func TestSearchTrace(t *testing.T) {
+	loadDataset(t)
+
+	const query = "element=Golem&mode=bfs&recipe_mode=single&timing=false"
+	record := func(format string) *Trace {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleSearchTrace(w, httptest.NewRequest(http.MethodGet, "/search/trace?format="+format+"&"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", format, w.Code)
+		}
+		trace, err := ReadTrace(w.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		return trace
+	}
+
+	trace := record("json")
+	encoded, _ := json.Marshal(trace)
+	for _, format := range []string{"ndjson", "json"} {
+		if again, _ := json.Marshal(record(format)); string(again) != string(encoded) {
+			t.Errorf("%s trace differs:\n%s\nwant\n%s", format, again, encoded)
+		}
+	}
+
+	if trace.Version != traceVersion || trace.Dataset != datasetHash || trace.Element != "Golem" ||
+		trace.Mode != "bfs" || trace.RecipeMode != "single" || trace.Recorded != "" {
+		t.Errorf("header %+v", trace.TraceHeader)
+	}
+	counts := make(map[string]int)
+	for i, entry := range trace.Entries {
+		if entry.Seq != i+1 || entry.T != 0 {
+			t.Fatalf("entry %d: seq %d, t %v", i, entry.Seq, entry.T)
+		}
+		counts[entry.Type]++
+	}
+
+	var want struct {
+		Found bool     `json:"found"`
+		Steps int      `json:"steps"`
+		Paths [][]Step `json:"paths"`
+	}
+	if code := getJSON(t, handleSearch, "/search?"+query, "", &want); code != http.StatusOK {
+		t.Fatalf("search status %d", code)
+	}
+	result := trace.Result
+	if result == nil || !result.Found || result.Steps != want.Steps || fmt.Sprint(result.Paths) != fmt.Sprint(want.Paths) {
+		t.Fatalf("trace result %+v, /search %+v", result, want)
+	}
+
+	var replay bytes.Buffer
+	ReplayTrace(&replay, trace, 0, false)
+	output := replay.String()
+	path := result.Paths[0]
+	last := path[len(path)-1]
+	for _, line := range []string{
+		"Trace of bfs search for Golem (single)",
+		fmt.Sprintf("%d events: %d visits, %d pushes,", len(trace.Entries), counts[EventVisit], counts[EventPush]),
+		fmt.Sprintf("Found 1 recipe(s), %d nodes visited", result.Steps),
+		fmt.Sprintf("  Golem = %s", strings.Join(last.Ingredients, " + ")),
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("replay lacks %q", line)
+		}
+	}
+	if lines := strings.Count(output, "ms  "); lines != len(trace.Entries) {
+		t.Errorf("replay shows %d entries, want %d", lines, len(trace.Entries))
+	}
+
+	w := httptest.NewRecorder()
+	handleSearchTrace(w, httptest.NewRequest(http.MethodGet, "/search/trace?format=xml&"+query, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("format=xml: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestMetrics checks that /metrics counts an answered search in its request
+// counter and histograms, and describes the loaded dataset.
+func TestMetrics(t *testing.T) {
+	loadDataset(t)
+
+	// scrape returns the samples of /metrics in order, as series and value.
+	scrape := func() ([]string, map[string]float64) {
+		w := httptest.NewRecorder()
+		handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			t.Fatalf("content type %q", w.Header().Get("Content-Type"))
+		}
+		var series []string
+		values := make(map[string]float64)
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, value, _ := strings.Cut(line, " ")
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("sample %q: %v", line, err)
+			}
+			series = append(series, name)
+			values[name] = parsed
+		}
+		return series, values
+	}
+	// sum adds up the samples whose series starts with prefix.
+	sum := func(values map[string]float64, prefix string) float64 {
+		total := 0.0
+		for name, value := range values {
+			if strings.HasPrefix(name, prefix) {
+				total += value
+			}
+		}
+		return total
+	}
+
+	labels := `{mode="dfs",recipe_mode="single"`
+	_, before := scrape()
+	handleSearch(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search?element=Brick&mode=dfs&recipe_mode=single", nil))
+	series, after := scrape()
+
+	for _, name := range []string{
+		"littlealchemy_search_requests_total" + labels,
+		"littlealchemy_search_duration_seconds_count" + labels,
+		"littlealchemy_search_visited_nodes_count" + labels,
+	} {
+		if got, want := sum(after, name), sum(before, name)+1; got != want {
+			t.Errorf("%s: %v, want %v", name, got, want)
+		}
+	}
+
+	previous := 0.0
+	for _, name := range series {
+		if !strings.HasPrefix(name, "littlealchemy_search_duration_seconds_bucket"+labels) {
+			continue
+		}
+		if after[name] < previous {
+			t.Errorf("%s: %v below the previous bucket's %v", name, after[name], previous)
+		}
+		previous = after[name]
+	}
+	if count := after["littlealchemy_search_duration_seconds_count"+labels+"}"]; previous != count {
+		t.Errorf("+Inf bucket %v, count %v", previous, count)
+	}
+
+	recipes := 0
+	for _, combos := range combinations {
+		recipes += len(combos)
+	}
+	if after["littlealchemy_dataset_elements"] != float64(len(tierMap)) || after["littlealchemy_dataset_recipes"] != float64(recipes) {
+		t.Errorf("dataset: %v elements, %v recipes; want %d and %d",
+			after["littlealchemy_dataset_elements"], after["littlealchemy_dataset_recipes"], len(tierMap), recipes)
+	}
+}
+
+// TestCompare checks that /compare answers for every algorithm in order with
+// a recipe that crafts the target, reports its length and depth, and names
+// as winners exactly the algorithms with the lowest value.
+func TestCompare(t *testing.T) {
+	loadDataset(t)
+
+	var response CompareResponse
+	if code := getJSON(t, handleCompare, "/compare?element=Golem&timing=false", "", &response); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if response.Target.Element != "Golem" || response.Target.Tier != tierMap["Golem"] || len(response.Results) != len(singleRecipeModes) {
+		t.Fatalf("target %+v, %d results", response.Target, len(response.Results))
+	}
+
+	best := map[string]int{}
+	for i, result := range response.Results {
+		if result.Algorithm != singleRecipeModes[i] || !result.Found || result.Visited <= 0 {
+			t.Errorf("result %d: %s, found %v, visited %d", i, result.Algorithm, result.Found, result.Visited)
+			continue
+		}
+		depth := make(map[string]int)
+		for _, step := range result.Recipe {
+			for _, ingredient := range step.Ingredients {
+				if _, made := depth[ingredient]; !made && !isBasic(ingredient) {
+					t.Errorf("%s: %s is used before it is made", result.Algorithm, ingredient)
+				}
+			}
+			depth[step.Result] = 1 + max(depth[step.Ingredients[0]], depth[step.Ingredients[1]])
+		}
+		last := result.Recipe[len(result.Recipe)-1].Result
+		if last != "Golem" || result.Steps != len(result.Recipe) || result.Depth != depth["Golem"] {
+			t.Errorf("%s: ends with %s, %d steps and depth %d for %d steps and depth %d",
+				result.Algorithm, last, result.Steps, result.Depth, len(result.Recipe), depth["Golem"])
+		}
+		for metric, value := range map[string]int{"steps": result.Steps, "depth": result.Depth, "visited": result.Visited} {
+			if current, ok := best[metric]; !ok || value < current {
+				best[metric] = value
+			}
+		}
+	}
+	if _, timed := response.Winners["time"]; timed || len(response.Winners) != 3 {
+		t.Errorf("winners %v with timing=false", response.Winners)
+	}
+	for metric, winners := range response.Winners {
+		var want []string
+		for _, result := range response.Results {
+			value := map[string]int{"steps": result.Steps, "depth": result.Depth, "visited": result.Visited}[metric]
+			if value == best[metric] {
+				want = append(want, result.Algorithm)
+			}
+		}
+		if fmt.Sprint(winners) != fmt.Sprint(want) {
+			t.Errorf("%s winners %v, want %v", metric, winners, want)
+		}
+	}
+
+	if code := getJSON(t, handleCompare, "/compare", "", &response); code != http.StatusBadRequest {
+		t.Errorf("no element: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SearchSnapshot is the state of a paused search. Frontier holds the
+// elements waiting to be expanded: the queue for BFS and bidirectional, the
+// current path from the target down for DFS. Visited lists the expanded
+// elements in order. The backward half of a bidirectional search is
+// reported separately, and BFS names its pass in Phase ("collect" or
+// "build").
+type SearchSnapshot struct {
+	Phase            string   `json:"phase,omitempty"`
+	Frontier         []string `json:"frontier"`
+	BackwardFrontier []string `json:"backwardFrontier,omitempty"`
+	Visited          []string `json:"visited"`
+	BackwardVisited  []string `json:"backwardVisited,omitempty"`
+	VisitedCount     int      `json:"visitedCount"`
+	Done             bool     `json:"done"`
+	Found            bool     `json:"found"`
+}
+
+// steppableSearch is a single-recipe search that runs one expansion at a
+// time. Step reports whether there is more to do; Result is only
+// meaningful once it has returned false.
+type steppableSearch interface {
+	Step() bool
+	Snapshot() SearchSnapshot
+	Result() *Node
+}
+
+// settledSearch is a search whose answer was known before it started.
+type settledSearch struct {
+	result  *Node
+	visited int
+}
+
+func (s *settledSearch) Step() bool    { return false }
+func (s *settledSearch) Result() *Node { return s.result }
+
+func (s *settledSearch) Snapshot() SearchSnapshot {
+	snapshot := SearchSnapshot{Frontier: []string{}, Visited: []string{}, VisitedCount: s.visited, Done: true, Found: s.result != nil}
+	if s.result != nil {
+		snapshot.Visited = []string{s.result.Element}
+	}
+	return snapshot
+}
+
+// newSteppableSearch prepares the single-recipe search mode runs for
+// element. The search does not start until its first Step, and logs to the
+// logger of ctx.
+func newSteppableSearch(ctx context.Context, element, mode string, cons *Constraints, observe SearchObserver) steppableSearch {
+	switch mode {
+	case "dfs":
+		return newDFSSearch(element, make(map[string]bool), cons, observe)
+	case "bidirectional":
+		if !cons.AllowsElement(element) {
+			return &settledSearch{}
+		}
+		if isBasic(element) {
+			return &settledSearch{result: &Node{Element: element}, visited: 1}
+		}
+		if _, exists := combinations[element]; !exists {
+			return &settledSearch{}
+		}
+		return newBidirectionalSearch(ctx, element, cons, false, 0, observe)
+	default:
+		return newBFSSearch(ctx, element, cons, observe)
+	}
+}
+
+// SessionAction is a message from a session client. Action is "step", "run",
+// "pause" or "cancel"; Delay is the pause in milliseconds between the steps
+// of a run.
+type SessionAction struct {
+	Action string `json:"action"`
+	Delay  int    `json:"delay"`
+}
+
+// SessionMessage is what the server pushes after every action and, during a
+// run, after every step. Events are the search events since the previous
+// message. Result is set once the search is done.
+type SessionMessage struct {
+	Type   string          `json:"type"`
+	Action string          `json:"action,omitempty"`
+	State  *SearchSnapshot `json:"state,omitempty"`
+	Events []SearchEvent   `json:"events,omitempty"`
+	Result *SearchResponse `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// searchSession is one client controlling one search over a WebSocket. mu
+// guards the search and the events it has reported since the last message.
+type searchSession struct {
+	conn *websocketConn
+	req  *searchRequest
+	log  *slog.Logger
+
+	mu     sync.Mutex
+	search steppableSearch
+	events []SearchEvent
+	result *SearchResponse
+	err    error
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// handleSearchSession opens a WebSocket on which the client steps through a
+// single-recipe search. The query takes the /search parameters, with
+// recipe_mode defaulting to single. The server pushes the state right away
+// and after every action.
+func handleSearchSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("recipe_mode") == "" {
+		query.Set("recipe_mode", "single")
+	}
+	req, err := parseSearchRequest(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.recipeMode != "single" {
+		http.Error(w, "Sessions only support single recipe mode", http.StatusBadRequest)
+		return
+	}
+
+	log := requestLogger(r.Context())
+	conn, err := upgradeWebsocket(w, r)
+	if err != nil {
+		log.Warn("websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	session := &searchSession{conn: conn, req: req, log: log}
+	session.search = newSteppableSearch(r.Context(), req.element, req.mode, req.cons, func(event SearchEvent) {
+		session.events = append(session.events, event)
+	})
+	defer session.pause()
+
+	session.mu.Lock()
+	session.push("start")
+	session.mu.Unlock()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, errWebsocketClosed) {
+				log.Warn("websocket read failed", "error", err)
+			}
+			return
+		}
+
+		var action SessionAction
+		if err := json.Unmarshal(data, &action); err != nil {
+			session.fail("Invalid message: " + err.Error())
+			continue
+		}
+
+		switch action.Action {
+		case "step":
+			if session.running() {
+				session.fail("Pause the run before stepping")
+				continue
+			}
+			session.step("step")
+		case "run":
+			if session.running() {
+				session.fail("Already running")
+				continue
+			}
+			session.run(time.Duration(max(action.Delay, 0)) * time.Millisecond)
+		case "pause":
+			session.pause()
+			session.mu.Lock()
+			session.push("pause")
+			session.mu.Unlock()
+		case "cancel":
+			session.pause()
+			session.mu.Lock()
+			session.push("cancel")
+			session.mu.Unlock()
+			return
+		default:
+			session.fail("Unknown action: " + action.Action)
+		}
+	}
+}
+
+// step advances the search by one expansion and pushes the new state. It
+// reports whether there is more to do.
+func (s *searchSession) step(action string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	more := s.search.Step()
+	s.push(action)
+	return more
+}
+
+// push sends the current state along with the events since the last push.
+// The caller holds mu.
+func (s *searchSession) push(action string) {
+	snapshot := s.search.Snapshot()
+	message := SessionMessage{Type: "state", Action: action, State: &snapshot, Events: s.events}
+	s.events = nil
+
+	if snapshot.Done {
+		if s.result == nil && s.err == nil {
+			var node *Node
+			node, s.err = enforceConstraints(s.req.element, s.search.Result(), s.req.cons)
+			if s.err == nil {
+				s.result = &SearchResponse{}
+				s.result.Target.Element = s.req.element
+				s.result.Target.Tier = tierMap[s.req.element]
+				if node != nil {
+					s.result.setResults(s.req, []*Node{node}, snapshot.VisitedCount)
+				}
+			}
+		}
+		if s.err != nil {
+			s.send(message)
+			s.fail(s.err.Error())
+			return
+		}
+		message.Result = s.result
+	}
+	s.send(message)
+}
+
+func (s *searchSession) fail(text string) {
+	s.send(SessionMessage{Type: "error", Error: text})
+}
+
+func (s *searchSession) send(message SessionMessage) {
+	data, _ := json.Marshal(message)
+	if err := s.conn.WriteText(data); err != nil {
+		s.log.Warn("websocket write failed", "error", err)
+	}
+}
+
+func (s *searchSession) running() bool {
+	if s.stopped == nil {
+		return false
+	}
+	select {
+	case <-s.stopped:
+		return false
+	default:
+		return true
+	}
+}
+
+// run steps the search in the background, waiting delay between steps,
+// until it is done or paused.
+func (s *searchSession) run(delay time.Duration) {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	s.stop, s.stopped = stop, stopped
+
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if !s.step("run") {
+				return
+			}
+			if delay > 0 {
+				select {
+				case <-stop:
+					return
+				case <-time.After(delay):
+				}
+			}
+		}
+	}()
+}
+
+// pause stops a run, if any, and waits for its current step to finish.
+func (s *searchSession) pause() {
+	if s.stopped == nil {
+		return
+	}
+	select {
+	case <-s.stopped:
+	default:
+		close(s.stop)
+		<-s.stopped
+	}
+	s.stop, s.stopped = nil, nil
+}
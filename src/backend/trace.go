@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceVersion is bumped whenever the layout of a trace changes.
+const traceVersion = 1
+
+// TraceHeader describes the search a trace was recorded from. Query holds
+// the search parameters, so the same search can be run again. Recorded is
+// left out of traces recorded with timing=false.
+type TraceHeader struct {
+	Version    int    `json:"version"`
+	Dataset    string `json:"dataset"`
+	Element    string `json:"element"`
+	Mode       string `json:"mode"`
+	RecipeMode string `json:"recipeMode"`
+	Query      string `json:"query"`
+	Recorded   string `json:"recorded,omitempty"`
+}
+
+// TraceEntry is one recorded search event. Seq numbers the entries from 1
+// and T is the time since the search started in milliseconds, or 0 for
+// traces recorded with timing=false.
+type TraceEntry struct {
+	Seq int     `json:"seq"`
+	T   float64 `json:"t,omitempty"`
+	SearchEvent
+}
+
+// Trace is the full record of one search: every event in the order it
+// happened, and the /search response it ended with. Single-recipe searches
+// record the same entries every time; multiple-recipe searches run their
+// ingredient searches in parallel, so their entries interleave differently
+// from run to run.
+type Trace struct {
+	TraceHeader
+	Entries []TraceEntry    `json:"entries"`
+	Result  *SearchResponse `json:"result"`
+}
+
+// traceRecorder is a SearchObserver that keeps every event it sees.
+type traceRecorder struct {
+	mu     sync.Mutex
+	start  time.Time
+	timing bool
+	trace  *Trace
+}
+
+func (rec *traceRecorder) observe(event SearchEvent) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	entry := TraceEntry{Seq: len(rec.trace.Entries) + 1, SearchEvent: event}
+	if rec.timing {
+		entry.T = float64(time.Since(rec.start).Microseconds()) / 1000.0
+	}
+	rec.trace.Entries = append(rec.trace.Entries, entry)
+}
+
+// RecordSearch runs req live, bypassing the solution index and recipe cache,
+// and returns its trace. query is stored in the trace header. It fails if
+// the search gives up.
+func RecordSearch(req *searchRequest, query url.Values) (*Trace, error) {
+	rec := &traceRecorder{
+		start:  time.Now(),
+		timing: req.timing,
+		trace: &Trace{
+			TraceHeader: TraceHeader{
+				Version:    traceVersion,
+				Dataset:    datasetHash,
+				Element:    req.element,
+				Mode:       req.mode,
+				RecipeMode: req.recipeMode,
+				Query:      query.Encode(),
+			},
+			Entries: []TraceEntry{},
+		},
+	}
+	if req.timing {
+		rec.trace.Recorded = rec.start.UTC().Format(time.RFC3339Nano)
+	}
+	result, err := runSearch(req, rec.observe)
+	if err != nil {
+		return nil, err
+	}
+	rec.trace.Result = result
+	return rec.trace, nil
+}
+
+// WriteTraceNDJSON writes trace as newline-delimited JSON: a line with the
+// header and type "trace", a line per entry, and a last line of type
+// "result" carrying the response.
+func WriteTraceNDJSON(w io.Writer, trace *Trace) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(struct {
+		Type string `json:"type"`
+		TraceHeader
+	}{"trace", trace.TraceHeader}); err != nil {
+		return err
+	}
+	for _, entry := range trace.Entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(TraceEntry{
+		Seq:         len(trace.Entries) + 1,
+		SearchEvent: SearchEvent{Type: EventResult, Search: trace.Element, Result: trace.Result},
+	})
+}
+
+// ReadTrace reads a trace in either of the formats /search/trace writes.
+func ReadTrace(r io.Reader) (*Trace, error) {
+	dec := json.NewDecoder(r)
+	var first struct {
+		Type string `json:"type"`
+		Trace
+	}
+	if err := dec.Decode(&first); err != nil {
+		return nil, err
+	}
+	trace := &first.Trace
+	if first.Type != "trace" {
+		if trace.Version == 0 {
+			return nil, errors.New("not a search trace")
+		}
+		return trace, nil
+	}
+
+	trace.Entries = []TraceEntry{}
+	for {
+		var entry TraceEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return trace, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Type == EventResult {
+			trace.Result = entry.Result
+			continue
+		}
+		trace.Entries = append(trace.Entries, entry)
+	}
+}
+
+// ReplayTrace renders trace on w one entry per line, followed by the recipe
+// it found. Between entries it waits delay or, when realtime is set, as long
+// as the search took between them.
+func ReplayTrace(w io.Writer, trace *Trace, delay time.Duration, realtime bool) {
+	fmt.Fprintf(w, "Trace of %s search for %s (%s)\n", trace.Mode, trace.Element, trace.RecipeMode)
+	if trace.Query != "" {
+		fmt.Fprintf(w, "Query: %s\n", trace.Query)
+	}
+	if trace.Dataset != datasetHash {
+		fmt.Fprintln(w, "Warning: the trace was recorded on another dataset")
+	}
+
+	counts := make(map[string]int)
+	last := 0.0
+	for _, entry := range trace.Entries {
+		switch {
+		case realtime && entry.T > last:
+			time.Sleep(time.Duration((entry.T - last) * float64(time.Millisecond)))
+		case delay > 0:
+			time.Sleep(delay)
+		}
+		last = entry.T
+		counts[entry.Type]++
+		fmt.Fprintln(w, formatTraceEntry(entry, trace.Element))
+	}
+
+	fmt.Fprintf(w, "\n%d events: %d visits, %d pushes, %d skips, %d recipes, %d meets\n", len(trace.Entries),
+		counts[EventVisit], counts[EventPush], counts[EventSkip], counts[EventRecipe], counts[EventMeet])
+	result := trace.Result
+	if result == nil || !result.Found {
+		fmt.Fprintln(w, "No recipe found")
+		return
+	}
+	fmt.Fprintf(w, "Found %d recipe(s), %d nodes visited\n", len(result.Paths), result.Steps)
+	for i, path := range result.Paths {
+		fmt.Fprintf(w, "Recipe %d:\n", i+1)
+		for _, step := range path {
+			fmt.Fprintf(w, "  %s = %s\n", step.Result, strings.Join(step.Ingredients, " + "))
+		}
+	}
+}
+
+// formatTraceEntry renders one entry. Entries of ingredient searches, whose
+// Search differs from the traced element, name the search they belong to.
+func formatTraceEntry(entry TraceEntry, element string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%5d %10.3fms  %-6s ", entry.Seq, entry.T, entry.Type)
+	if entry.Search != "" && entry.Search != element {
+		fmt.Fprintf(&b, "[%s] ", entry.Search)
+	}
+	if entry.Direction != "" {
+		fmt.Fprintf(&b, "%-8s ", entry.Direction)
+	}
+
+	switch entry.Type {
+	case EventVisit:
+		fmt.Fprintf(&b, "%s (visited %d, frontier %d)", entry.Element, entry.Visited, entry.Frontier)
+	case EventPush:
+		b.WriteString(entry.Element)
+		if entry.Parent != "" {
+			fmt.Fprintf(&b, " for %s", entry.Parent)
+		}
+	case EventRecipe:
+		fmt.Fprintf(&b, "%s = %s + %s", entry.Element, entry.Left, entry.Right)
+	case EventSkip:
+		fmt.Fprintf(&b, "%s = %s + %s (%s)", entry.Element, entry.Left, entry.Right, entry.Reason)
+	default:
+		b.WriteString(entry.Element)
+	}
+	return b.String()
+}
+
+// handleSearchTrace runs a search like /search and returns its trace. format
+// is json (the default) or ndjson.
+func handleSearchTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "ndjson" {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+	query.Del("format")
+
+	req, err := parseSearchRequest(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.log = requestLogger(r.Context())
+	req.ctx = r.Context()
+	trace, err := RecordSearch(req, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = WriteTraceNDJSON(w, trace)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(trace)
+	}
+	if err != nil {
+		requestLogger(r.Context()).Error("encoding trace", "error", err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// RecipeTree is the nested form of a submitted recipe. A node without
+// ingredients is a leaf.
+type RecipeTree struct {
+	Element string      `json:"element"`
+	Left    *RecipeTree `json:"left,omitempty"`
+	Right   *RecipeTree `json:"right,omitempty"`
+}
+
+// ValidationRequest is the body of POST /validate. Exactly one of Recipe and
+// Steps must be set. Inventory lists elements the player already has, which
+// may be used like basic elements.
+type ValidationRequest struct {
+	Recipe    *RecipeTree `json:"recipe"`
+	Steps     []Step      `json:"steps"`
+	Inventory []string    `json:"inventory"`
+}
+
+// ValidationError locates one problem in a submitted recipe. Step is the
+// 1-based number of the offending step in crafting order, and Path is where
+// it sits in the submitted JSON.
+type ValidationError struct {
+	Kind    string `json:"kind"`
+	Step    int    `json:"step"`
+	Path    string `json:"path"`
+	Element string `json:"element"`
+	Message string `json:"message"`
+}
+
+// Validation error kinds.
+const (
+	errMalformed   = "malformed"
+	errNoCombo     = "missing_combination"
+	errTierRule    = "tier_rule"
+	errUnavailable = "unavailable_ingredient"
+)
+
+// Verdict is the answer of POST /validate. The three booleans say which of
+// the checks passed; FirstError is the earliest problem in crafting order.
+type Verdict struct {
+	Valid                bool              `json:"valid"`
+	Target               string            `json:"target"`
+	Steps                int               `json:"steps"`
+	CombinationsExist    bool              `json:"combinationsExist"`
+	LowerTier            bool              `json:"lowerTier"`
+	IngredientsAvailable bool              `json:"ingredientsAvailable"`
+	FirstError           *ValidationError  `json:"firstError"`
+	Errors               []ValidationError `json:"errors"`
+}
+
+// locatedStep is one crafting step of a submission along with where it and
+// its two ingredients were found. leaf marks ingredients that no earlier step
+// makes, which must be basic or in the inventory.
+type locatedStep struct {
+	left, right, result string
+	path                string
+	ingredientPaths     [2]string
+	leaf                [2]bool
+	malformed           string
+}
+
+// treeSteps lists the steps of tree in crafting order, children before
+// parents, the same order convertRecipeToPath uses. Leaves are left to
+// markLeaves.
+func treeSteps(tree *RecipeTree, path string) []locatedStep {
+	if tree == nil || (tree.Left == nil && tree.Right == nil) {
+		return nil
+	}
+	if tree.Left == nil || tree.Right == nil {
+		return []locatedStep{{result: tree.Element, path: path, malformed: tree.Element + " needs two ingredients"}}
+	}
+
+	steps := treeSteps(tree.Left, path+".left")
+	steps = append(steps, treeSteps(tree.Right, path+".right")...)
+	return append(steps, locatedStep{
+		left:            tree.Left.Element,
+		right:           tree.Right.Element,
+		result:          tree.Element,
+		path:            path,
+		ingredientPaths: [2]string{path + ".left", path + ".right"},
+	})
+}
+
+// listSteps locates the steps of a Step list. Leaves are left to markLeaves.
+func listSteps(steps []Step) []locatedStep {
+	located := make([]locatedStep, 0, len(steps))
+	for i, step := range steps {
+		path := "steps[" + strconv.Itoa(i) + "]"
+		if len(step.Ingredients) != 2 {
+			located = append(located, locatedStep{result: step.Result, path: path, malformed: "a step needs exactly two ingredients"})
+			continue
+		}
+		left, right := step.Ingredients[0], step.Ingredients[1]
+		located = append(located, locatedStep{
+			left:            left,
+			right:           right,
+			result:          step.Result,
+			path:            path,
+			ingredientPaths: [2]string{path + ".ingredients[0]", path + ".ingredients[1]"},
+		})
+	}
+	return located
+}
+
+// markLeaves marks the ingredients of each step that no earlier step makes.
+// Trees and step lists share this rule, so a recipe gets the same verdict in
+// either form: an element a tree uses as a leaf counts as made if another
+// branch crafted it before.
+func markLeaves(steps []locatedStep) {
+	made := make(map[string]bool)
+	for i := range steps {
+		if steps[i].malformed != "" {
+			continue
+		}
+		steps[i].leaf = [2]bool{!made[steps[i].left], !made[steps[i].right]}
+		made[steps[i].result] = true
+	}
+}
+
+// ValidateSubmission checks every step of a submitted recipe against the
+// dataset: the combination must exist, it must satisfy the strict lower-tier
+// rule, and ingredients that no earlier step makes must be basic or in
+// inventory. A tree that is a single basic or inventory element is a valid
+// recipe of zero steps.
+func ValidateSubmission(req ValidationRequest) Verdict {
+	inventory := make(map[string]bool, len(req.Inventory))
+	for _, elem := range req.Inventory {
+		inventory[elem] = true
+	}
+
+	var steps []locatedStep
+	target := ""
+	if req.Recipe != nil {
+		steps = treeSteps(req.Recipe, "recipe")
+		target = req.Recipe.Element
+		if len(steps) == 0 && !isBasic(target) && !inventory[target] {
+			steps = []locatedStep{{result: target, path: "recipe", malformed: target + " is neither basic nor in the inventory, and has no ingredients"}}
+		}
+	} else {
+		steps = listSteps(req.Steps)
+		if len(req.Steps) == 0 {
+			steps = []locatedStep{{path: "steps", malformed: "the step list is empty"}}
+		} else {
+			target = req.Steps[len(req.Steps)-1].Result
+		}
+	}
+	markLeaves(steps)
+
+	verdict := Verdict{
+		Target:               target,
+		Steps:                len(steps),
+		CombinationsExist:    true,
+		LowerTier:            true,
+		IngredientsAvailable: true,
+		Errors:               []ValidationError{},
+	}
+	report := func(kind string, number int, path, element, message string) {
+		verdict.Errors = append(verdict.Errors, ValidationError{
+			Kind: kind, Step: number, Path: path, Element: element, Message: message,
+		})
+	}
+
+	for i, step := range steps {
+		number := i + 1
+		if step.malformed != "" {
+			verdict.CombinationsExist = false
+			report(errMalformed, number, step.path, step.result, step.malformed)
+			continue
+		}
+
+		for side, ingredient := range []string{step.left, step.right} {
+			if step.leaf[side] && !isBasic(ingredient) && !inventory[ingredient] {
+				verdict.IngredientsAvailable = false
+				report(errUnavailable, number, step.ingredientPaths[side], ingredient,
+					fmt.Sprintf("%s is neither basic, in the inventory, nor made by an earlier step", ingredient))
+			}
+		}
+
+		combos := matchingCombos(step.result, step.left, step.right)
+		if len(combos) == 0 {
+			verdict.CombinationsExist = false
+			report(errNoCombo, number, step.path, step.result,
+				fmt.Sprintf("no combination makes %s from %s and %s", step.result, step.left, step.right))
+			continue
+		}
+		lowerTier := false
+		for _, c := range combos {
+			lowerTier = lowerTier || IsLowerTier(c)
+		}
+		if !lowerTier {
+			verdict.LowerTier = false
+			report(errTierRule, number, step.path, step.result,
+				fmt.Sprintf("%s + %s = %s does not use lower-tier ingredients (tiers %d, %d -> %d)",
+					step.left, step.right, step.result, tierMap[step.left], tierMap[step.right], tierMap[step.result]))
+		}
+	}
+
+	verdict.Valid = len(verdict.Errors) == 0
+	if !verdict.Valid {
+		verdict.FirstError = &verdict.Errors[0]
+	}
+	return verdict
+}
+
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ValidationRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (req.Recipe == nil) == (req.Steps == nil) {
+		http.Error(w, "Provide either recipe or steps", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ValidateSubmission(req))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// websocketGUID is the key suffix RFC 6455 uses for the opening handshake.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebsocketMessage bounds the size of a message a client may send.
+const maxWebsocketMessage = 1 << 16
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+var errWebsocketClosed = errors.New("websocket closed")
+
+// websocketConn is the server side of a WebSocket connection, just enough of
+// RFC 6455 for small JSON messages: it reads whole messages, answers pings
+// and closes, and writes unfragmented text messages. Writes may come from
+// several goroutines; reads must come from one.
+type websocketConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// upgradeWebsocket completes the opening handshake of a WebSocket request.
+// Browsers send the page's Origin, which must be the server's own or one of
+// AllowedOrigins; clients that send none are not browsers and are let in, as
+// CORS would. On failure it has already answered the request with an error.
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+		return nil, errors.New("unsupported websocket version")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) && !originAllowed(origin) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return nil, errors.New("origin not allowed")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// sameOrigin reports whether origin names the host the request was sent to.
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, host)
+}
+
+// headerContains reports whether one of the comma-separated values of header
+// name is token, ignoring case.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readFrame reads one frame from the client, which must be masked.
+func (c *websocketConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.reader, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return fin, opcode, nil, errors.New("unmasked client frame")
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWebsocketMessage {
+		return fin, opcode, nil, errors.New("websocket frame too large")
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns the next text or binary message, answering pings and
+// closes on the way. It returns errWebsocketClosed once the client closes.
+func (c *websocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return nil, errWebsocketClosed
+		case wsText, wsBinary, wsContinuation:
+			message = append(message, payload...)
+			if len(message) > maxWebsocketMessage {
+				return nil, errors.New("websocket message too large")
+			}
+			if fin {
+				return message, nil
+			}
+		default:
+			return nil, errors.New("unknown websocket opcode")
+		}
+	}
+}
+
+// WriteText sends data as one text message.
+func (c *websocketConn) WriteText(data []byte) error {
+	return c.writeFrame(wsText, data)
+}
+
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	head := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		head = append(head, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		head = append(head, 126)
+		head = binary.BigEndian.AppendUint16(head, uint16(len(payload)))
+	default:
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(len(payload)))
+	}
+	if _, err := c.conn.Write(append(head, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close sends a normal closure and closes the connection.
+func (c *websocketConn) Close() error {
+	c.writeFrame(wsClose, []byte{0x03, 0xE8})
+	return c.conn.Close()
+}